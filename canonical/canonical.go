@@ -0,0 +1,90 @@
+package canonical
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// number of names which were not in canonical form
+var changed int64
+
+// Changed returns the number of names seen which were not in canonical form.
+func Changed() int64 {
+	return atomic.LoadInt64(&changed)
+}
+
+// Name returns the canonical form of a domain name (RFC 4034, section 6.2):
+// fully qualified, all letters in lower case and escapes only where needed,
+// so names which compare equal are equal as strings.
+func Name(name string) string {
+	if !strings.ContainsAny(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ\\") && dns.IsFqdn(name) {
+		return name
+	}
+	wire := make([]byte, 256)
+	off, err := dns.PackDomainName(dns.Fqdn(name), wire, 0, nil, false)
+	if err != nil {
+		// not a valid name, at least use lower case
+		return strings.ToLower(name)
+	}
+	// label lengths are below 64, so only letters are changed
+	for i := 0; i < off; i++ {
+		if wire[i] >= 'A' && wire[i] <= 'Z' {
+			wire[i] += 'a' - 'A'
+		}
+	}
+	canonical, _, err := dns.UnpackDomainName(wire[:off], 0)
+	if err != nil {
+		return strings.ToLower(name)
+	}
+	return canonical
+}
+
+// RR changes the owner name and all domain names in the rdata of a
+// resource record to canonical form.
+func RR(rr dns.RR) {
+	header := rr.Header()
+	header.Name = canonicalize(header.Name)
+	value := reflect.ValueOf(rr).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag.Get("dns")
+		if tag != "domain-name" && tag != "cdomain-name" {
+			continue
+		}
+		field := value.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(canonicalize(field.String()))
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.String {
+				for j := 0; j < field.Len(); j++ {
+					field.Index(j).SetString(canonicalize(field.Index(j).String()))
+				}
+			}
+		}
+	}
+}
+
+// canonicalize returns the canonical form of a name and counts it if it changed
+func canonicalize(name string) string {
+	canonical := Name(name)
+	if canonical != name {
+		atomic.AddInt64(&changed, 1)
+	}
+	return canonical
+}
+
+// Filter passes all records on with their names in canonical form.
+func Filter(rrlist <-chan dns.RR) <-chan dns.RR {
+	out := make(chan dns.RR, 10000)
+	go func() {
+		for rr := range rrlist {
+			RR(rr)
+			out <- rr
+		}
+		close(out)
+	}()
+	return out
+}
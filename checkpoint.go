@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/checkpoint"
+	"github.com/ulrichwisser/zonestats/shard"
+)
+
+// Cached plugins save the results of slow network checks in the
+// checkpoint, so a resumed run does not need to repeat them.
+type Cached interface {
+	Name() string
+	SaveCache() ([]byte, error)
+	LoadCache([]byte) error
+}
+
+// checkpointState is the state of checkpointing of the run. While the zone is
+// read, a checkpoint is written by runPlugins between two records once all
+// records before are processed, so the aggregates of the plugins belong to
+// exactly these records. Afterwards only the results of the network checks
+// change, they are written by the goroutine started by startCheckpoints.
+type checkpointState struct {
+	access  sync.Mutex
+	config  *Configuration
+	serial  uint32
+	reading bool
+	// due is signalled by the goroutine when runPlugins shall write a checkpoint
+	due chan struct{}
+	// stop ends the goroutine, stopped is closed when it has returned
+	stop    chan struct{}
+	stopped chan struct{}
+	// records given to the plugins and the last of them
+	records uint64
+	last    dns.RR
+	// the records and aggregates of the last checkpoint written
+	written   uint64
+	writtenRR string
+	partial   string
+}
+
+var checkpoints = checkpointState{}
+
+// cached returns the registry and all plugins with results of network checks
+func cached() []Cached {
+	list := []Cached{registry}
+	for _, plugin := range plugins {
+		if c, ok := plugin.(Cached); ok {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// aggregating reports if the aggregates of all plugins can be checkpointed,
+// plugins which can not be used in sharded runs can not save them either
+func aggregating() bool {
+	for _, plugin := range plugins {
+		if _, ok := plugin.(Mergeable); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func initCheckpoints(config *Configuration, serial uint32) {
+	checkpoints = checkpointState{
+		config:  config,
+		serial:  serial,
+		reading: true,
+		due:     make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// checkpointing reports if checkpoints are written in this run
+func checkpointing() bool {
+	return checkpoints.config != nil
+}
+
+// loadCheckpoint restores the state of the last checkpoint. It must be for
+// the same zone serial (and shard) as the current run. The records of the
+// checkpoint have to be skipped with skipCheckpoint.
+func loadCheckpoint() {
+	config := checkpoints.config
+	saved, err := checkpoint.Read(config.Checkpoint)
+	if os.IsNotExist(err) {
+		slog.Warn("no checkpoint found, starting from the beginning", "file", config.Checkpoint)
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	err = saved.Check(config.Zone, checkpoints.serial, config.Shard, config.Shards)
+	if err != nil {
+		panic(err)
+	}
+	if len(saved.Partial) > 0 {
+		if !aggregating() {
+			panic(fmt.Errorf("checkpoint %s has aggregates, but not all plugins can restore them", config.Checkpoint))
+		}
+		partial, err := shard.Read(saved.Partial)
+		if err != nil {
+			panic(err)
+		}
+		mergeInto([]*shard.Partial{partial})
+		checkpoints.records = saved.Records
+		checkpoints.written = saved.Records
+		checkpoints.writtenRR = saved.Last
+		checkpoints.partial = saved.Partial
+	}
+	for _, c := range cached() {
+		state, ok := saved.Cache[c.Name()]
+		if !ok {
+			continue
+		}
+		err = c.LoadCache(state)
+		if err != nil {
+			panic(err)
+		}
+	}
+	slog.Info("resuming from checkpoint", "file", config.Checkpoint, "written", saved.Written, "records", saved.Records)
+}
+
+// skipCheckpoint drops the records which are part of the checkpoint loaded
+func skipCheckpoint(rrlist <-chan dns.RR) <-chan dns.RR {
+	return checkpoint.Skip(rrlist, checkpoints.written, checkpoints.writtenRR)
+}
+
+// received counts a record given to the plugins. If a checkpoint is due, it
+// waits until all records are processed and writes it.
+func received(rr dns.RR, wg *sync.WaitGroup) {
+	checkpoints.records++
+	checkpoints.last = rr
+	select {
+	case <-checkpoints.due:
+		wg.Wait()
+		writeCheckpoint()
+	default:
+	}
+}
+
+// doneReading writes a checkpoint with all records, all records must be
+// processed. Later checkpoints only update the results of network checks.
+func doneReading() {
+	writeCheckpoint()
+	checkpoints.access.Lock()
+	checkpoints.reading = false
+	checkpoints.access.Unlock()
+}
+
+// writeCheckpoint writes the aggregates of all plugins to a new partial
+// result file and a checkpoint pointing to it. The partial result of the
+// previous checkpoint is removed afterwards. If anything fails, the
+// checkpoint is skipped.
+func writeCheckpoint() {
+	config := checkpoints.config
+	filename := ""
+	if aggregating() && checkpoints.records > checkpoints.written {
+		partial, err := newPartial(config, -1, time.Time{})
+		if err == nil {
+			filename = fmt.Sprintf("%s.%d.partial", config.Checkpoint, checkpoints.records)
+			err = partial.Write(filename)
+		}
+		if err != nil {
+			slog.Error("can not write checkpoint", "file", filename, "error", err)
+			os.Remove(filename)
+			return
+		}
+	}
+
+	checkpoints.access.Lock()
+	previous := checkpoints.partial
+	if len(filename) > 0 {
+		checkpoints.written = checkpoints.records
+		checkpoints.writtenRR = checkpoints.last.String()
+		checkpoints.partial = filename
+	}
+	checkpoints.access.Unlock()
+	if writeCache() && len(filename) > 0 && len(previous) > 0 {
+		os.Remove(previous)
+	}
+}
+
+// writeCache writes the checkpoint with the results of the network checks,
+// the aggregates are the ones of the last checkpoint. It reports if the
+// checkpoint was written.
+func writeCache() bool {
+	config := checkpoints.config
+	checkpoints.access.Lock()
+	current := checkpoint.New(config.Zone, checkpoints.serial, config.Shard, config.Shards)
+	current.Records = checkpoints.written
+	current.Last = checkpoints.writtenRR
+	current.Partial = checkpoints.partial
+	checkpoints.access.Unlock()
+	for _, c := range cached() {
+		state, err := c.SaveCache()
+		if err != nil {
+			slog.Error("can not save checkpoint", "plugin", c.Name(), "error", err)
+			return false
+		}
+		current.Cache[c.Name()] = state
+	}
+	err := current.Write(config.Checkpoint)
+	if err != nil {
+		slog.Error("can not write checkpoint", "file", config.Checkpoint, "error", err)
+		return false
+	}
+	slog.Debug("checkpoint written", "file", config.Checkpoint, "records", current.Records)
+	return true
+}
+
+// startCheckpoints writes checkpoints every interval until
+// finishCheckpoints is called. While the zone is read, runPlugins is asked
+// to write them.
+func startCheckpoints() {
+	go func() {
+		defer close(checkpoints.stopped)
+		ticker := time.NewTicker(time.Duration(checkpoints.config.CheckpointInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkpoints.access.Lock()
+				reading := checkpoints.reading
+				checkpoints.access.Unlock()
+				if !reading {
+					writeCache()
+					continue
+				}
+				select {
+				case checkpoints.due <- struct{}{}:
+				default:
+				}
+			case <-checkpoints.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finishCheckpoints stops writing checkpoints and removes the files, they
+// are not needed after a successful run. It waits for a write in progress,
+// so no file is written again after it was removed.
+func finishCheckpoints() {
+	config := checkpoints.config
+	close(checkpoints.stop)
+	<-checkpoints.stopped
+	partials, _ := filepath.Glob(config.Checkpoint + ".*.partial")
+	for _, filename := range append(partials, config.Checkpoint) {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("can not remove checkpoint", "file", filename, "error", err)
+		}
+	}
+}
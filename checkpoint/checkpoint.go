@@ -0,0 +1,156 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// VERSION of the checkpoint file format.
+const VERSION = 2
+
+// Checkpoint is the state of a run after the first Records records of the
+// zone. Last is the last of these records in presentation format, a
+// resumed run checks that it reads the records in the same order.
+// Partial is the file with the aggregates of all plugins after these
+// records, written in the format of the partial results of sharded runs.
+// It is empty if the aggregates are not checkpointed, the whole zone is
+// read again then.
+// Cache holds the results of the network checks (resolved hosts, checked
+// delegations) by name, they are not repeated by a resumed run.
+type Checkpoint struct {
+	Version uint
+	Zone    string
+	Serial  uint32
+	Shard   uint
+	Shards  uint
+	Written time.Time
+	Records uint64
+	Last    string
+	Partial string
+	Cache   map[string]json.RawMessage
+}
+
+func New(zone string, serial uint32, shard uint, shards uint) *Checkpoint {
+	self := Checkpoint{}
+	self.Version = VERSION
+	self.Zone = zone
+	self.Serial = serial
+	self.Shard = shard
+	self.Shards = shards
+	self.Cache = make(map[string]json.RawMessage)
+	return &self
+}
+
+// Write saves the checkpoint. The file is replaced atomically, so a run
+// dying while writing does not destroy the previous checkpoint.
+func (self *Checkpoint) Write(filename string) error {
+	self.Written = time.Now()
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Read loads a checkpoint from a file.
+func Read(filename string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	self := &Checkpoint{}
+	err = json.Unmarshal(data, self)
+	if err != nil {
+		return nil, err
+	}
+	if self.Version != VERSION {
+		return nil, fmt.Errorf("%s: checkpoint version %d not supported (expected %d)", filename, self.Version, VERSION)
+	}
+	return self, nil
+}
+
+// Check makes sure the checkpoint belongs to the same zone, serial and shard.
+func (self *Checkpoint) Check(zone string, serial uint32, shard uint, shards uint) error {
+	if dns.Fqdn(self.Zone) != dns.Fqdn(zone) {
+		return fmt.Errorf("checkpoint is for zone %s, not %s", self.Zone, zone)
+	}
+	if self.Serial != serial {
+		return fmt.Errorf("checkpoint is for serial %d, zone has serial %d", self.Serial, serial)
+	}
+	if self.Shard != shard || self.Shards != shards {
+		return fmt.Errorf("checkpoint is for shard %d of %d", self.Shard, self.Shards)
+	}
+	return nil
+}
+
+// Serial reads the first record, which must be the SOA record, and returns
+// its serial. The returned channel still delivers all records.
+func Serial(rrlist <-chan dns.RR) (uint32, <-chan dns.RR, error) {
+	first, ok := <-rrlist
+	out := make(chan dns.RR, 10000)
+	go func() {
+		if ok {
+			out <- first
+			for rr := range rrlist {
+				out <- rr
+			}
+		}
+		close(out)
+	}()
+	soa, isSOA := first.(*dns.SOA)
+	if !ok || !isSOA {
+		return 0, out, fmt.Errorf("zone does not start with a SOA record")
+	}
+	return soa.Serial, out, nil
+}
+
+// Skip drops the first records of a resumed run, they are part of the
+// checkpoint. The last record dropped must be last, otherwise the zone is
+// not read in the same order and the run can not be resumed.
+func Skip(rrlist <-chan dns.RR, records uint64, last string) <-chan dns.RR {
+	if records == 0 {
+		return rrlist
+	}
+	out := make(chan dns.RR, 10000)
+	go func() {
+		var n uint64
+		for rr := range rrlist {
+			n++
+			if n < records {
+				continue
+			}
+			if n == records {
+				if rr.String() != last {
+					panic(fmt.Errorf("record %d is %s, the checkpoint has %s, the zone is not read in the same order", n, rr.String(), last))
+				}
+				continue
+			}
+			out <- rr
+		}
+		if n < records {
+			panic(fmt.Errorf("zone has %d records, the checkpoint has %d", n, records))
+		}
+		close(out)
+	}()
+	return out
+}
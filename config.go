@@ -5,27 +5,116 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"os/user"
 	"path"
+	"strings"
 
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/canonical"
 	"github.com/ulrichwisser/zonestats/dnsresolver"
+	"github.com/ulrichwisser/zonestats/hll"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/plugins/counter"
+	"github.com/ulrichwisser/zonestats/plugins/external"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
 type Configuration struct {
-	Dryrun       bool
-	Filename     string
-	Axfr         string
-	Source       string
-	Zone         string
-	Resolvers    stringslice
-	Port         uint
-	InfluxServer string
-	InfluxDB     string
-	InfluxUser   string
-	InfluxPasswd string
+	Dryrun             bool
+	Offline            bool
+	Merge              bool
+	Shard              uint
+	Shards             uint
+	Partial            string
+	MemoryLimit        uint
+	SpillDir           string
+	Approximate        bool
+	LogLevel           string
+	LogFormat          string
+	Progress           uint
+	Precision          uint
+	Filename           string
+	Axfr               string
+	Source             string
+	Zone               string
+	Resolvers          stringslice
+	Port               uint
+	InfluxServer       string
+	InfluxDB           string
+	InfluxUser         string
+	InfluxPasswd       string
+	InfluxVersion      uint
+	InfluxOrg          string
+	InfluxBucket       string
+	InfluxToken        string
+	InfluxPrecision    string
+	InfluxBatch        uint
+	InfluxGzip         bool
+	InfluxRetries      uint
+	InfluxSpool        string
+	Sinks              sinklist
+	Output             string
+	OutFile            string
+	Timestamp          string
+	TimePrecision      string
+	Tags               tagmap
+	TagLimits          []outputs.TagLimit
+	Checkpoint         string
+	CheckpointInterval uint
+	Resume             bool
+	Lame               bool
+	Sample             float64
+	Seed               int64
+	Stratify           bool
+	External           []external.Config
+	Counters           []counter.Config
+}
+
+// sinklist collects the sinks given on the command line as type, type:file,
+// type:url or type:address
+type sinklist []outputs.Config
+
+func (self *sinklist) String() string {
+	return fmt.Sprintf("%v", *self)
+}
+
+func (self *sinklist) Set(value string) error {
+	conf := outputs.Config{Type: value}
+	if i := strings.Index(value, ":"); i >= 0 {
+		conf.Type = value[:i]
+		switch conf.Type {
+		case outputs.PUSHGATEWAY, outputs.OTLP:
+			conf.URL = value[i+1:]
+		case outputs.GRAPHITE, outputs.STATSD:
+			conf.Address = value[i+1:]
+		default:
+			conf.File = value[i+1:]
+		}
+	}
+	*self = append(*self, conf)
+	return nil
+}
+
+// tagmap collects the static tags given on the command line as name=value
+type tagmap map[string]string
+
+func (self *tagmap) String() string {
+	return fmt.Sprintf("%v", *self)
+}
+
+func (self *tagmap) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return fmt.Errorf("tag %s is not name=value", value)
+	}
+	if *self == nil {
+		*self = make(tagmap)
+	}
+	(*self)[value[:i]] = value[i+1:]
+	return nil
 }
 
 func parseCmdline() *Configuration {
@@ -35,15 +124,48 @@ func parseCmdline() *Configuration {
 	// define and parse command line arguments
 	flag.StringVar(&conffilename, "conf", "", "Filename to read configuration from")
 	flag.BoolVar(&config.Dryrun, "dryrun", false, "Print results instead of writing to InfluxDB")
+	flag.BoolVar(&config.Offline, "offline", false, "Do not use the network, compute statistics from the zone only")
 	flag.StringVar(&config.Filename, "infile", "", "filename of zone file")
 	flag.StringVar(&config.Axfr, "axfr", "", "server adress to request axfr")
 	flag.StringVar(&config.Zone, "zone", "", "zone for axfr")
 	flag.UintVar(&config.Port, "port", 53, "port for axfr")
 	flag.Var(&config.Resolvers, "resolver", "resolver name or ip")
+	flag.UintVar(&config.Shard, "shard", 0, "number of the shard to run (0 to shards-1)")
+	flag.UintVar(&config.Shards, "shards", 0, "split the zone into this many shards")
+	flag.StringVar(&config.Partial, "partial", "", "filename to write the partial result of a shard to")
+	flag.UintVar(&config.MemoryLimit, "memlimit", 0, "memory (in MB) for name sets before spilling to disk (0 no limit)")
+	flag.StringVar(&config.SpillDir, "spilldir", "", "directory for spill files")
+	flag.StringVar(&config.LogLevel, "loglevel", "", "log level: debug, info, warn or error (default info)")
+	flag.StringVar(&config.LogFormat, "logformat", "", "log format: text or json (default text)")
+	flag.UintVar(&config.Progress, "progress", 0, "report progress every n seconds (0 no reports)")
+	flag.StringVar(&config.Checkpoint, "checkpoint", "", "file to write checkpoints of the run to")
+	flag.UintVar(&config.CheckpointInterval, "checkpointinterval", 0, "write a checkpoint every n seconds (default 300)")
+	flag.BoolVar(&config.Resume, "resume", false, "resume from the last checkpoint of a failed run")
+	flag.BoolVar(&config.Lame, "lame", false, "check a sample of delegations for lame name servers")
+	flag.Float64Var(&config.Sample, "sample", 0, "fraction of delegations checked by network plugins (default 1, all)")
+	flag.Int64Var(&config.Seed, "seed", 0, "seed selecting the sample")
+	flag.BoolVar(&config.Stratify, "stratify", false, "post-stratify the estimate by DNSSEC status")
+	flag.BoolVar(&config.Approximate, "approximate", false, "estimate distinct counts with HyperLogLog")
+	flag.UintVar(&config.Precision, "precision", 0, "HyperLogLog precision (4 to 18, default 14)")
+	flag.StringVar(&config.Timestamp, "timestamp", "", "timestamp of the results: now, serial, mtime or a time (default now)")
+	flag.StringVar(&config.TimePrecision, "timeprecision", "", "precision of the timestamp: ns, us, ms or s (default s)")
+	flag.Var(&config.Tags, "tag", "static tag name=value added to every point")
+	flag.StringVar(&config.Output, "output", "", "write the results as json or csv (instead of InfluxDB, unless sinks are given)")
+	flag.StringVar(&config.OutFile, "outfile", "", "file to write the results of output to (default STDOUT)")
+	flag.Var(&config.Sinks, "sink", "sink to write results to: influx, archive:<file>, prometheus:<file>, pushgateway:<url>, graphite:<address>, statsd:<address>, otlp:<url>, sqlite:<file>, parquet:<file>, json[:<file>] or csv[:<file>] (default influx)")
 	flag.StringVar(&config.InfluxServer, "influxServer", "", "Server with InfluxDB running")
 	flag.StringVar(&config.InfluxDB, "influxDB", "", "Name of InfluxDB database")
 	flag.StringVar(&config.InfluxUser, "influxUser", "", "Name of InfluxDB user")
 	flag.StringVar(&config.InfluxPasswd, "influxPasswd", "", "Name of InfluxDB user password")
+	flag.UintVar(&config.InfluxVersion, "influxVersion", 0, "InfluxDB write API: 1 or 2 (InfluxDB 2.x and 3.x, default 1)")
+	flag.StringVar(&config.InfluxOrg, "influxOrg", "", "InfluxDB organization (write API 2)")
+	flag.StringVar(&config.InfluxBucket, "influxBucket", "", "InfluxDB bucket (write API 2)")
+	flag.StringVar(&config.InfluxToken, "influxToken", "", "InfluxDB token (write API 2)")
+	flag.StringVar(&config.InfluxPrecision, "influxPrecision", "", "precision of timestamps: ns, us, ms or s (default ns)")
+	flag.UintVar(&config.InfluxBatch, "influxBatch", 0, "number of lines sent to InfluxDB in one request (default 5000)")
+	flag.BoolVar(&config.InfluxGzip, "influxGzip", false, "compress requests to InfluxDB with gzip")
+	flag.UintVar(&config.InfluxRetries, "influxRetries", 0, "number of retries of failed requests to InfluxDB (default 3)")
+	flag.StringVar(&config.InfluxSpool, "influxSpool", "", "directory to keep batches which could not be sent until the next run")
 	flag.Parse()
 
 	var confFromFile *Configuration
@@ -112,6 +234,101 @@ func joinConfig(oldConf *Configuration, newConf *Configuration) (config *Configu
 	} else {
 		config.Dryrun = false
 	}
+	if newConf.Approximate || oldConf.Approximate {
+		config.Approximate = true
+	} else {
+		config.Approximate = false
+	}
+	if newConf.Resume || oldConf.Resume {
+		config.Resume = true
+	} else {
+		config.Resume = false
+	}
+	if newConf.Lame || oldConf.Lame {
+		config.Lame = true
+	} else {
+		config.Lame = false
+	}
+	if newConf.Stratify || oldConf.Stratify {
+		config.Stratify = true
+	} else {
+		config.Stratify = false
+	}
+	if newConf.InfluxGzip || oldConf.InfluxGzip {
+		config.InfluxGzip = true
+	} else {
+		config.InfluxGzip = false
+	}
+	if newConf.Offline || oldConf.Offline {
+		config.Offline = true
+	} else {
+		config.Offline = false
+	}
+	if newConf.Shard != 0 {
+		config.Shard = newConf.Shard
+	} else {
+		config.Shard = oldConf.Shard
+	}
+	if newConf.Shards != 0 {
+		config.Shards = newConf.Shards
+	} else {
+		config.Shards = oldConf.Shards
+	}
+	if newConf.Partial != "" {
+		config.Partial = newConf.Partial
+	} else {
+		config.Partial = oldConf.Partial
+	}
+	if newConf.MemoryLimit != 0 {
+		config.MemoryLimit = newConf.MemoryLimit
+	} else {
+		config.MemoryLimit = oldConf.MemoryLimit
+	}
+	if newConf.SpillDir != "" {
+		config.SpillDir = newConf.SpillDir
+	} else {
+		config.SpillDir = oldConf.SpillDir
+	}
+	if newConf.Precision != 0 {
+		config.Precision = newConf.Precision
+	} else {
+		config.Precision = oldConf.Precision
+	}
+	if newConf.LogLevel != "" {
+		config.LogLevel = newConf.LogLevel
+	} else {
+		config.LogLevel = oldConf.LogLevel
+	}
+	if newConf.LogFormat != "" {
+		config.LogFormat = newConf.LogFormat
+	} else {
+		config.LogFormat = oldConf.LogFormat
+	}
+	if newConf.Progress != 0 {
+		config.Progress = newConf.Progress
+	} else {
+		config.Progress = oldConf.Progress
+	}
+	if newConf.Checkpoint != "" {
+		config.Checkpoint = newConf.Checkpoint
+	} else {
+		config.Checkpoint = oldConf.Checkpoint
+	}
+	if newConf.CheckpointInterval != 0 {
+		config.CheckpointInterval = newConf.CheckpointInterval
+	} else {
+		config.CheckpointInterval = oldConf.CheckpointInterval
+	}
+	if newConf.Sample != 0 {
+		config.Sample = newConf.Sample
+	} else {
+		config.Sample = oldConf.Sample
+	}
+	if newConf.Seed != 0 {
+		config.Seed = newConf.Seed
+	} else {
+		config.Seed = oldConf.Seed
+	}
 	if newConf.Filename != "" {
 		config.Filename = newConf.Filename
 	} else {
@@ -132,6 +349,54 @@ func joinConfig(oldConf *Configuration, newConf *Configuration) (config *Configu
 	} else {
 		config.Zone = oldConf.Zone
 	}
+	if len(newConf.External) > 0 {
+		config.External = newConf.External
+	} else {
+		config.External = oldConf.External
+	}
+	if len(newConf.Counters) > 0 {
+		config.Counters = newConf.Counters
+	} else {
+		config.Counters = oldConf.Counters
+	}
+	if newConf.Timestamp != "" {
+		config.Timestamp = newConf.Timestamp
+	} else {
+		config.Timestamp = oldConf.Timestamp
+	}
+	// tags are joined, new values replace old ones
+	config.Tags = make(tagmap)
+	for name, value := range oldConf.Tags {
+		config.Tags[name] = value
+	}
+	for name, value := range newConf.Tags {
+		config.Tags[name] = value
+	}
+	if len(newConf.TagLimits) > 0 {
+		config.TagLimits = newConf.TagLimits
+	} else {
+		config.TagLimits = oldConf.TagLimits
+	}
+	if newConf.TimePrecision != "" {
+		config.TimePrecision = newConf.TimePrecision
+	} else {
+		config.TimePrecision = oldConf.TimePrecision
+	}
+	if newConf.Output != "" {
+		config.Output = newConf.Output
+	} else {
+		config.Output = oldConf.Output
+	}
+	if newConf.OutFile != "" {
+		config.OutFile = newConf.OutFile
+	} else {
+		config.OutFile = oldConf.OutFile
+	}
+	if len(newConf.Sinks) > 0 {
+		config.Sinks = newConf.Sinks
+	} else {
+		config.Sinks = oldConf.Sinks
+	}
 	if newConf.InfluxServer != "" {
 		config.InfluxServer = newConf.InfluxServer
 	} else {
@@ -152,22 +417,177 @@ func joinConfig(oldConf *Configuration, newConf *Configuration) (config *Configu
 	} else {
 		config.InfluxPasswd = oldConf.InfluxPasswd
 	}
+	if newConf.InfluxVersion != 0 {
+		config.InfluxVersion = newConf.InfluxVersion
+	} else {
+		config.InfluxVersion = oldConf.InfluxVersion
+	}
+	if newConf.InfluxOrg != "" {
+		config.InfluxOrg = newConf.InfluxOrg
+	} else {
+		config.InfluxOrg = oldConf.InfluxOrg
+	}
+	if newConf.InfluxBucket != "" {
+		config.InfluxBucket = newConf.InfluxBucket
+	} else {
+		config.InfluxBucket = oldConf.InfluxBucket
+	}
+	if newConf.InfluxToken != "" {
+		config.InfluxToken = newConf.InfluxToken
+	} else {
+		config.InfluxToken = oldConf.InfluxToken
+	}
+	if newConf.InfluxPrecision != "" {
+		config.InfluxPrecision = newConf.InfluxPrecision
+	} else {
+		config.InfluxPrecision = oldConf.InfluxPrecision
+	}
+	if newConf.InfluxBatch != 0 {
+		config.InfluxBatch = newConf.InfluxBatch
+	} else {
+		config.InfluxBatch = oldConf.InfluxBatch
+	}
+	if newConf.InfluxRetries != 0 {
+		config.InfluxRetries = newConf.InfluxRetries
+	} else {
+		config.InfluxRetries = oldConf.InfluxRetries
+	}
+	if newConf.InfluxSpool != "" {
+		config.InfluxSpool = newConf.InfluxSpool
+	} else {
+		config.InfluxSpool = oldConf.InfluxSpool
+	}
 
 	// Done
 	return config
 }
 
+// initLogging sets up the default logger, all logs are written to STDERR.
+func initLogging(config *Configuration) {
+	var level slog.Level
+	switch config.LogLevel {
+	case "", "info":
+		level = slog.LevelInfo
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		panic(fmt.Errorf("unknown log level %s", config.LogLevel))
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch config.LogFormat {
+	case "", "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, options)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, options)))
+	default:
+		panic(fmt.Errorf("unknown log format %s", config.LogFormat))
+	}
+}
+
 func usage() {
 	os.Exit(1)
 }
 func checkConfiguration(config *Configuration) *Configuration {
-	// Get resolvers to use
-	if len(config.Resolvers) == 0 {
-		config.Resolvers = dnsresolver.GetDefaultResolvers()
+	// The zone is compared with the canonical names of the records
+	config.Zone = canonicalZone(config.Zone)
+
+	// Approximate counts
+	if config.Approximate {
+		if config.Precision == 0 {
+			config.Precision = 14
+		}
+		if config.Precision < hll.MINPRECISION || config.Precision > hll.MAXPRECISION {
+			panic(fmt.Errorf("precision must be between %d and %d", hll.MINPRECISION, hll.MAXPRECISION))
+		}
+	} else if config.Precision > 0 {
+		panic(errors.New("precision can only be given together with approximate"))
 	}
-	if len(config.Resolvers) == 0 {
-		fmt.Println("No resolver(s) found.")
-		usage()
+
+	// External plugins can not save partial results
+	if (config.Merge || config.Shards > 0) && len(config.External) > 0 {
+		panic(errors.New("external plugins can not be used in sharded runs"))
+	}
+
+	// Checkpoints
+	if config.Resume && len(config.Checkpoint) == 0 {
+		panic(errors.New("resume needs the checkpoint file"))
+	}
+	if config.Merge && len(config.Checkpoint) > 0 {
+		panic(errors.New("checkpoints can not be used when merging partial results"))
+	}
+	if config.CheckpointInterval == 0 {
+		config.CheckpointInterval = 300
+	}
+
+	// Sampling of network checks
+	if config.Sample == 0 {
+		config.Sample = 1
+	}
+	if config.Sample < 0 || config.Sample > 1 {
+		panic(errors.New("sample must be between 0 and 1"))
+	}
+	if (config.Merge || config.Shards > 0) && config.Lame {
+		panic(errors.New("lame delegation check can not be used in sharded runs"))
+	}
+
+	// Timestamps
+	if len(config.TimePrecision) == 0 {
+		config.TimePrecision = "s"
+	}
+	if _, ok := outputs.PRECISIONS[config.TimePrecision]; !ok {
+		panic(fmt.Errorf("unknown time precision %s", config.TimePrecision))
+	}
+	switch config.Timestamp {
+	case "", NOW, SERIAL:
+	case MTIME:
+		if config.Merge || len(config.Filename) == 0 {
+			panic(errors.New("timestamp mtime can only be used with infile"))
+		}
+	default:
+		if _, err := parseTimestamp(config.Timestamp); err != nil {
+			panic(err)
+		}
+	}
+
+	// Tags
+	for name, value := range config.Tags {
+		if len(name) == 0 || len(value) == 0 {
+			panic(fmt.Errorf("tag %s=%s needs a name and a value", name, value))
+		}
+		if name == "tld" || name == "source" {
+			panic(fmt.Errorf("tag %s is set by zonestats", name))
+		}
+	}
+	for _, limit := range config.TagLimits {
+		if len(limit.Measurement) == 0 || len(limit.Tag) == 0 {
+			panic(errors.New("tag limits need a measurement and a tag"))
+		}
+		if limit.Limit == 0 {
+			panic(fmt.Errorf("limit of tag %s of %s must be at least 1", limit.Tag, limit.Measurement))
+		}
+	}
+
+	// Merging partial results needs neither input nor resolvers
+	if config.Merge {
+		if config.Shards > 0 {
+			panic(errors.New("shards can not be given when merging partial results"))
+		}
+		return checkSinkConfiguration(config)
+	}
+
+	// Get resolvers to use
+	if !config.Offline {
+		if len(config.Resolvers) == 0 {
+			config.Resolvers = dnsresolver.GetDefaultResolvers()
+		}
+		if len(config.Resolvers) == 0 {
+			slog.Error("No resolver(s) found.")
+			usage()
+		}
 	}
 
 	if (len(config.Filename) > 0) && (len(config.Axfr) > 0) {
@@ -182,6 +602,9 @@ func checkConfiguration(config *Configuration) *Configuration {
 	if len(config.Axfr) > 0 {
 		config.Source = "axfr"
 	}
+	if config.Offline && config.Source == "axfr" {
+		panic(errors.New("axfr can not be used in offline mode"))
+	}
 	if config.Port == 0 {
 		panic(errors.New("port must be given"))
 	}
@@ -189,22 +612,122 @@ func checkConfiguration(config *Configuration) *Configuration {
 		panic(errors.New("zone must be given"))
 	}
 
+	// Sharded runs
+	if config.Shards > 0 {
+		if config.Shard >= config.Shards {
+			panic(errors.New("shard must be less than shards"))
+		}
+		if len(config.Partial) == 0 {
+			config.Partial = fmt.Sprintf("%s%d-%d.partial", dns.Fqdn(config.Zone), config.Shard, config.Shards)
+		}
+		return config
+	}
+	if config.Shard > 0 || len(config.Partial) > 0 {
+		panic(errors.New("shard and partial can only be given together with shards"))
+	}
+
+	return checkSinkConfiguration(config)
+}
+
+// canonicalZone returns the zone in canonical form (lower case). A zone given
+// without the trailing dot is returned without, so the tld tag stays the same.
+func canonicalZone(zone string) string {
+	if len(zone) == 0 {
+		return zone
+	}
+	if dns.IsFqdn(zone) {
+		return canonical.Name(zone)
+	}
+	return strings.TrimSuffix(canonical.Name(zone), ".")
+}
+
+func checkSinkConfiguration(config *Configuration) *Configuration {
+	// output adds a sink writing json or csv, given alone it is the only sink
+	if len(config.Output) > 0 {
+		if config.Output != outputs.JSON && config.Output != outputs.CSV {
+			panic(fmt.Errorf("unknown output %s", config.Output))
+		}
+		config.Sinks = append(config.Sinks, outputs.Config{Type: config.Output, File: config.OutFile})
+	} else if len(config.OutFile) > 0 {
+		panic(errors.New("outfile can only be given together with output"))
+	}
+
+	// without sinks and output results are written to InfluxDB only
+	if len(config.Sinks) == 0 {
+		config.Sinks = sinklist{{Type: outputs.INFLUX}}
+	}
+	influx := false
+	for _, sink := range config.Sinks {
+		switch sink.Type {
+		case outputs.INFLUX:
+			if influx {
+				panic(errors.New("influx sink can only be given once"))
+			}
+			influx = true
+		case outputs.JSON, outputs.CSV:
+		case outputs.ARCHIVE, outputs.PROMETHEUS, outputs.SQLITE, outputs.PARQUET:
+			if len(sink.File) == 0 {
+				panic(fmt.Errorf("%s sink needs a file", sink.Type))
+			}
+		case outputs.PUSHGATEWAY, outputs.OTLP:
+			if len(sink.URL) == 0 {
+				panic(fmt.Errorf("%s sink needs an url", sink.Type))
+			}
+		case outputs.GRAPHITE, outputs.STATSD:
+			if len(sink.Address) == 0 {
+				panic(fmt.Errorf("%s sink needs an address", sink.Type))
+			}
+		default:
+			panic(fmt.Errorf("unknown sink %s", sink.Type))
+		}
+	}
+	if influx {
+		return checkInfluxConfiguration(config)
+	}
+	return config
+}
+
+func checkInfluxConfiguration(config *Configuration) *Configuration {
+	if config.InfluxVersion == 0 {
+		config.InfluxVersion = 1
+	}
+	if config.InfluxVersion != 1 && config.InfluxVersion != 2 {
+		panic(errors.New("influx version must be 1 or 2"))
+	}
+	if len(config.InfluxPrecision) == 0 {
+		config.InfluxPrecision = config.TimePrecision
+	}
+	if _, ok := outputs.PRECISIONS[config.InfluxPrecision]; !ok {
+		panic(fmt.Errorf("unknown influx precision %s", config.InfluxPrecision))
+	}
+
 	// Influx config
 	if !config.Dryrun {
 		if len(config.InfluxServer) == 0 {
-			fmt.Println("Influx server address must be given.")
+			slog.Error("Influx server address must be given.")
 			usage()
 		}
+		if config.InfluxVersion == 2 {
+			if len(config.InfluxBucket) == 0 {
+				slog.Error("Influx bucket must be given.")
+				usage()
+			}
+			if len(config.InfluxToken) == 0 {
+				slog.Error("Influx token must be given.")
+				usage()
+			}
+			return config
+		}
 		if len(config.InfluxDB) == 0 {
-			fmt.Println("Influx server address must be given.")
+			slog.Error("Influx server address must be given.")
 			usage()
 		}
 		if (len(config.InfluxUser) == 0) && (len(config.InfluxPasswd) > 0) {
-			fmt.Println("Influx user and password must be given (not only one).")
+			slog.Error("Influx user and password must be given (not only one).")
 			usage()
 		}
 		if (len(config.InfluxUser) > 0) && (len(config.InfluxPasswd) == 0) {
-			fmt.Println("Influx user and password must be given (not only one).")
+			slog.Error("Influx user and password must be given (not only one).")
 			usage()
 		}
 	}
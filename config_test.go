@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// TestOutputOnly checks that --output without sinks writes only the export
+// and with sinks is written in addition
+func TestOutputOnly(t *testing.T) {
+	config := &Configuration{Output: outputs.JSON}
+	checkSinkConfiguration(config)
+	if len(config.Sinks) != 1 || config.Sinks[0].Type != outputs.JSON {
+		t.Errorf("sinks %v, want only json", config.Sinks)
+	}
+
+	config = &Configuration{Output: outputs.CSV, OutFile: "out.csv", Sinks: sinklist{{Type: outputs.ARCHIVE, File: "archive.jsonl"}}}
+	checkSinkConfiguration(config)
+	if len(config.Sinks) != 2 || config.Sinks[1].Type != outputs.CSV || config.Sinks[1].File != "out.csv" {
+		t.Errorf("sinks %v, want archive and csv", config.Sinks)
+	}
+}
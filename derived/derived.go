@@ -0,0 +1,425 @@
+package derived
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/dnsresolver"
+	"github.com/ulrichwisser/zonestats/hostlist"
+	"github.com/ulrichwisser/zonestats/iplist"
+	"github.com/ulrichwisser/zonestats/spill"
+)
+
+// Names of the datasets plugins can depend on
+const (
+	// HostIPs are all name server hosts with their glue and resolved addresses
+	HostIPs = "HostIPs"
+	// DomainNS are the name server hosts of every delegation
+	DomainNS = "DomainNS"
+	// DomainDS are the DS records of every delegation
+	DomainDS = "DomainDS"
+)
+
+type DS struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     string
+}
+
+// Registry builds datasets derived from the zone which are shared between
+// plugins. Only datasets required by at least one plugin are built, each
+// of them once per run. Name server hosts are resolved only once, no matter
+// how many plugins use their addresses.
+type Registry struct {
+	access      sync.Mutex
+	origin      string
+	required    map[string]bool
+	resolver    *dnsresolver.Resolver
+	offline     bool
+	approximate bool
+	hostlist    hostlist.Hostlist
+	iplist      iplist.IPlist
+	domainNS    *spill.Set
+	domainDS    *spill.Set
+}
+
+// New creates an empty registry. Without a resolver no hosts are resolved,
+// offline tells if the addresses of hosts are known at all (merged partial
+// results of online runs have addresses but no resolver). In approximate
+// runs the datasets with an entry per delegation can not be required, they
+// would grow with the zone.
+func New(origin string, resolver *dnsresolver.Resolver, offline bool, approximate bool) *Registry {
+	self := Registry{}
+	self.origin = origin
+	self.required = make(map[string]bool)
+	self.resolver = resolver
+	self.offline = offline
+	self.approximate = approximate
+	self.hostlist = hostlist.Hostlist{}
+	self.hostlist.Init(origin)
+	self.iplist = iplist.IPlist{}
+	self.iplist.Init()
+	self.iplist.Offline = offline
+	self.domainNS = spill.New()
+	self.domainDS = spill.New()
+	return &self
+}
+
+// Require marks datasets as needed. It must be called before the first record is received.
+func (self *Registry) Require(names ...string) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, name := range names {
+		switch name {
+		case DomainNS, DomainDS:
+			if self.approximate {
+				return fmt.Errorf("dataset %s keeps every delegation and can not be used with --approximate", name)
+			}
+			self.required[name] = true
+		case HostIPs:
+			self.required[name] = true
+		default:
+			return fmt.Errorf("unknown dataset %s", name)
+		}
+	}
+	return nil
+}
+
+func (self *Registry) Required(name string) bool {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return self.required[name]
+}
+
+// Origin returns the name of the zone.
+func (self *Registry) Origin() string {
+	return self.origin
+}
+
+// Offline reports if hosts have not been resolved.
+func (self *Registry) Offline() bool {
+	return self.offline
+}
+
+// Hosts returns the HostIPs dataset.
+func (self *Registry) Hosts() *hostlist.Hostlist {
+	return &self.hostlist
+}
+
+// IPs returns all distinct addresses of the HostIPs dataset.
+func (self *Registry) IPs() *iplist.IPlist {
+	return &self.iplist
+}
+
+func (self *Registry) Receive(rr dns.RR, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	switch rr.(type) {
+	case *dns.NS:
+		hostname := rr.(*dns.NS).Ns
+		if self.Required(HostIPs) {
+			self.addHost(hostname, wg)
+		}
+		if self.Required(DomainNS) {
+			err := self.domainNS.Add(spill.Join(rr.Header().Name, hostname))
+			if err != nil {
+				panic(err)
+			}
+		}
+	case *dns.A:
+		if self.Required(HostIPs) {
+			glue := rr.(*dns.A).A
+			self.addHost(rr.Header().Name, wg).AddGlue(glue)
+			self.iplist.AddIP(glue, wg)
+		}
+	case *dns.AAAA:
+		if self.Required(HostIPs) {
+			glue := rr.(*dns.AAAA).AAAA
+			self.addHost(rr.Header().Name, wg).AddGlue(glue)
+			self.iplist.AddIP(glue, wg)
+		}
+	case *dns.DS:
+		if self.Required(DomainDS) {
+			ds := rr.(*dns.DS)
+			err := self.domainDS.Add(spill.Join(rr.Header().Name, strconv.Itoa(int(ds.KeyTag)), strconv.Itoa(int(ds.Algorithm)), strconv.Itoa(int(ds.DigestType)), ds.Digest))
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// addHost returns a host and starts resolving it when it is seen the first time
+func (self *Registry) addHost(hostname string, wg *sync.WaitGroup) *hostlist.Host {
+	host, added := self.hostlist.GetOrAdd(hostname)
+	if added && self.resolver != nil {
+		wg.Add(1)
+		go self.getIPs(host, wg)
+	}
+	return host
+}
+
+func (self *Registry) getIPs(host *hostlist.Host, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer host.SetResolved()
+	answer := self.resolver.Resolv(host.GetName(), dns.TypeA)
+	answer = append(answer, self.resolver.Resolv(host.GetName(), dns.TypeAAAA)...)
+	for _, answer := range answer {
+		if answer.Header().Rrtype == dns.TypeA {
+			host.AddIP(answer.(*dns.A).A)
+			self.iplist.AddIP(answer.(*dns.A).A, wg)
+		}
+		if answer.Header().Rrtype == dns.TypeAAAA {
+			host.AddIP(answer.(*dns.AAAA).AAAA)
+			self.iplist.AddIP(answer.(*dns.AAAA).AAAA, wg)
+		}
+	}
+}
+
+// WalkDomainNS calls fn for every delegation with its name server hosts, sorted by domain.
+func (self *Registry) WalkDomainNS(fn func(domain string, hosts []string)) error {
+	domain := ""
+	hosts := make([]string, 0)
+	err := self.domainNS.Walk(func(key string) {
+		parts := spill.Split(key)
+		if parts[0] != domain && len(hosts) > 0 {
+			fn(domain, hosts)
+			hosts = make([]string, 0)
+		}
+		domain = parts[0]
+		hosts = append(hosts, parts[1])
+	})
+	if err != nil {
+		return err
+	}
+	if len(hosts) > 0 {
+		fn(domain, hosts)
+	}
+	return nil
+}
+
+// WalkDomainDS calls fn for every signed delegation with its DS records, sorted by domain.
+func (self *Registry) WalkDomainDS(fn func(domain string, ds []DS)) error {
+	domain := ""
+	list := make([]DS, 0)
+	var converr error
+	err := self.domainDS.Walk(func(key string) {
+		parts := spill.Split(key)
+		if parts[0] != domain && len(list) > 0 {
+			fn(domain, list)
+			list = make([]DS, 0)
+		}
+		domain = parts[0]
+		keytag, err1 := strconv.ParseUint(parts[1], 10, 16)
+		alg, err2 := strconv.ParseUint(parts[2], 10, 8)
+		digest, err3 := strconv.ParseUint(parts[3], 10, 8)
+		for _, err := range []error{err1, err2, err3} {
+			if err != nil && converr == nil {
+				converr = err
+			}
+		}
+		list = append(list, DS{KeyTag: uint16(keytag), Algorithm: uint8(alg), DigestType: uint8(digest), Digest: parts[4]})
+	})
+	if err != nil {
+		return err
+	}
+	if converr != nil {
+		return converr
+	}
+	if len(list) > 0 {
+		fn(domain, list)
+	}
+	return nil
+}
+
+// WalkDelegations joins DomainNS and DomainDS. It calls fn for every
+// delegation with its name server hosts and DS records, sorted by domain.
+func (self *Registry) WalkDelegations(fn func(domain string, hosts []string, ds []DS)) error {
+	type domainDS struct {
+		domain string
+		ds     []DS
+	}
+	dslist := make(chan domainDS, 100)
+	var dserr error
+	go func() {
+		dserr = self.WalkDomainDS(func(domain string, ds []DS) { dslist <- domainDS{domain, ds} })
+		close(dslist)
+	}()
+	next, more := <-dslist
+	err := self.WalkDomainNS(func(domain string, hosts []string) {
+		for more && next.domain < domain {
+			fn(next.domain, []string{}, next.ds)
+			next, more = <-dslist
+		}
+		ds := []DS{}
+		if more && next.domain == domain {
+			ds = next.ds
+			next, more = <-dslist
+		}
+		fn(domain, hosts, ds)
+	})
+	for more {
+		if err == nil {
+			fn(next.domain, []string{}, next.ds)
+		}
+		next, more = <-dslist
+	}
+	if err != nil {
+		return err
+	}
+	return dserr
+}
+
+// HostStatus classifies a name server host by its glue and resolved
+// addresses with the most specific field name of the nsstats Hosts
+// measurement, e.g. InTldGlueIpMissmatch or ExTldNoIp. In offline mode
+// hosts are only classified by their glue.
+func (self *Registry) HostStatus(host *hostlist.Host) string {
+	host.Access.Lock()
+	defer host.Access.Unlock()
+	if !host.IsTldHost {
+		if !self.offline && len(host.IPs) == 0 {
+			return "ExTldNoIp"
+		}
+		return "ExTld"
+	}
+	if len(host.Glue) == 0 {
+		if !self.offline && len(host.IPs) == 0 {
+			return "InTldNoGlueNoIp"
+		}
+		return "InTldNoGlue"
+	}
+	if self.offline {
+		return "InTldGlue"
+	}
+	if len(host.IPs) == 0 {
+		return "InTldGlueNoIp"
+	}
+	if !sameIPs(host.Glue, host.IPs) {
+		return "InTldGlueIpMissmatch"
+	}
+	return "InTldGlueIp"
+}
+
+// sameIPs reports if both lists contain the same addresses
+func sameIPs(a []net.IP, b []net.IP) bool {
+	contains := func(list []net.IP, ip net.IP) bool {
+		for _, listip := range list {
+			if ip.Equal(listip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ip := range a {
+		if !contains(b, ip) {
+			return false
+		}
+	}
+	for _, ip := range b {
+		if !contains(a, ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close removes all spill files. The datasets can not be used afterwards.
+func (self *Registry) Close() {
+	self.domainNS.Close()
+	self.domainDS.Close()
+}
+
+// SaveCache saves the addresses of all resolved hosts.
+func (self *Registry) SaveCache() ([]byte, error) {
+	resolved := make(map[string][]net.IP)
+	for _, hostname := range self.hostlist.GetAllHostnames() {
+		host := self.hostlist.GetHost(hostname)
+		host.Access.Lock()
+		if host.Resolved {
+			resolved[hostname] = host.IPs
+		}
+		host.Access.Unlock()
+	}
+	return json.Marshal(resolved)
+}
+
+// LoadCache adds the hosts resolved before. They are not resolved again
+// when they are found in the zone. Hosts restored from the aggregates of a
+// checkpoint are only marked as resolved.
+func (self *Registry) LoadCache(data []byte) error {
+	resolved := make(map[string][]net.IP)
+	err := json.Unmarshal(data, &resolved)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for hostname, ips := range resolved {
+		host, _ := self.hostlist.GetOrAdd(hostname)
+		host.Merge(ips, nil)
+		for _, ip := range ips {
+			self.iplist.AddIP(ip, &wg)
+		}
+		host.SetResolved()
+	}
+	return nil
+}
+
+// partialHost is the state of one host saved in partial results
+type partialHost struct {
+	Name string
+	IPs  []net.IP
+	Glue []net.IP
+}
+
+type partialState struct {
+	Hosts []partialHost
+}
+
+func (self *Registry) Name() string {
+	return "Registry"
+}
+
+func (self *Registry) SavePartial() ([]byte, error) {
+	state := partialState{Hosts: make([]partialHost, 0)}
+	for _, hostname := range self.hostlist.GetAllHostnames() {
+		host := self.hostlist.GetHost(hostname)
+		host.Access.Lock()
+		state.Hosts = append(state.Hosts, partialHost{Name: host.Name, IPs: host.IPs, Glue: host.Glue})
+		host.Access.Unlock()
+	}
+	return json.Marshal(state)
+}
+
+// MergePartial adds the datasets of a partial result. Hosts found in several
+// shards are joined, so glue and resolved addresses from different shards
+// can be compared.
+func (self *Registry) MergePartial(data []byte) error {
+	state := partialState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, partial := range state.Hosts {
+		self.hostlist.AddHost(partial.Name).Merge(partial.IPs, partial.Glue)
+		for _, ip := range partial.IPs {
+			self.iplist.AddIP(ip, &wg)
+		}
+		for _, ip := range partial.Glue {
+			self.iplist.AddIP(ip, &wg)
+		}
+	}
+	return nil
+}
+
+// Sets returns the delegation datasets, they are saved to partial results
+// key by key.
+func (self *Registry) Sets() map[string]*spill.Set {
+	return map[string]*spill.Set{"DomainNS": self.domainNS, "DomainDS": self.domainDS}
+}
@@ -3,9 +3,11 @@ package dnsresolver
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -18,6 +20,13 @@ const (
 
 var ratelimiter = make(chan string, RATELIMIT)
 
+// number of queries waiting for or being resolved
+var outstanding int64
+
+// number of queries sent and failed
+var sent int64
+var failed int64
+
 type Resolver struct {
 	resolvers []string
 }
@@ -37,6 +46,8 @@ func New(resolvers []string) *Resolver {
 
 // resolv will send a query and return the result
 func (self *Resolver) Resolv(qname string, qtype uint16) []dns.RR {
+	atomic.AddInt64(&outstanding, 1)
+	defer atomic.AddInt64(&outstanding, -1)
 	ratelimiter <- "x"
 	defer func() { _ = <-ratelimiter }()
 
@@ -54,25 +65,63 @@ func (self *Resolver) Resolv(qname string, qtype uint16) []dns.RR {
 	server := self.resolvers[rand.Intn(len(self.resolvers))]
 
 	// make the query and wait for answer
+	atomic.AddInt64(&sent, 1)
 	r, _, err := client.Exchange(query, server)
 
 	// check for errors
+	if err != nil || r == nil || r.Rcode != dns.RcodeSuccess {
+		atomic.AddInt64(&failed, 1)
+	}
 	if err != nil {
-		//fmt.Printf("%-30s: Error resolving %s (server %s)\n", domain, err, server)
+		slog.Debug("error resolving", "qname", qname, "qtype", dns.Type(qtype).String(), "server", server, "error", err)
 		return nil
 	}
 	if r == nil {
-		//fmt.Printf("%-30s: No answer (Server %s)\n", domain, server)
+		slog.Debug("no answer", "qname", qname, "qtype", dns.Type(qtype).String(), "server", server)
 		return nil
 	}
 	if r.Rcode != dns.RcodeSuccess {
-		//fmt.Printf("%-30s: %s (Rcode %d, Server %s)\n", domain, rcode2string[r.Rcode], r.Rcode, server)
+		slog.Debug("query failed", "qname", qname, "qtype", dns.Type(qtype).String(), "server", server, "rcode", dns.RcodeToString[r.Rcode])
 		return nil
 	}
 
 	return r.Answer
 }
 
+// Query sends a non recursive query directly to a server (e.g. an authoritative
+// name server) and returns the response. It shares rate limit and counters with Resolv.
+func Query(qname string, qtype uint16, server string) (*dns.Msg, error) {
+	atomic.AddInt64(&outstanding, 1)
+	defer atomic.AddInt64(&outstanding, -1)
+	ratelimiter <- "x"
+	defer func() { _ = <-ratelimiter }()
+
+	query := new(dns.Msg)
+	query.SetQuestion(qname, qtype)
+	query.RecursionDesired = false
+
+	client := new(dns.Client)
+	client.ReadTimeout = TIMEOUT
+
+	atomic.AddInt64(&sent, 1)
+	r, _, err := client.Exchange(query, Ip2Resolver(server))
+	if err != nil || r == nil {
+		atomic.AddInt64(&failed, 1)
+		slog.Debug("error querying server", "qname", qname, "qtype", dns.Type(qtype).String(), "server", server, "error", err)
+	}
+	return r, err
+}
+
+// Outstanding returns the number of queries waiting for or being resolved.
+func Outstanding() int64 {
+	return atomic.LoadInt64(&outstanding)
+}
+
+// Queries returns the number of queries sent and the number of them that failed.
+func Queries() (int64, int64) {
+	return atomic.LoadInt64(&sent), atomic.LoadInt64(&failed)
+}
+
 func Ip2Resolver(server string) string {
 	if strings.ContainsAny(":", server) {
 		// IPv6 address
@@ -97,7 +146,7 @@ func GetDefaultResolvers() []string {
 		resolvers = append(resolvers, conf.Servers[i])
 	}
 	if len(resolvers) == 0 {
-		fmt.Println("No resolvers found.")
+		slog.Error("No resolvers found.")
 		os.Exit(5)
 	}
 	return resolvers
@@ -0,0 +1,115 @@
+package hll
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+const (
+	MINPRECISION uint = 4
+	MAXPRECISION uint = 18
+)
+
+// Sketch is a HyperLogLog sketch to estimate the number of distinct keys.
+// It uses 2^Precision registers of one byte, independent of the number of keys.
+type Sketch struct {
+	access    sync.Mutex
+	Precision uint
+	Registers []uint8
+}
+
+func New(precision uint) *Sketch {
+	if precision < MINPRECISION || precision > MAXPRECISION {
+		panic(fmt.Errorf("hyperloglog precision must be between %d and %d", MINPRECISION, MAXPRECISION))
+	}
+	self := Sketch{}
+	self.Precision = precision
+	self.Registers = make([]uint8, 1<<precision)
+	return &self
+}
+
+// hash returns a well mixed 64 bit hash of a key
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	// murmur3 finalizer, fnv alone does not mix the high bits well enough
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (self *Sketch) Add(key string) {
+	x := hash(key)
+	index := x >> (64 - self.Precision)
+	rank := uint8(bits.LeadingZeros64(x<<self.Precision|1<<(self.Precision-1)) + 1)
+	self.access.Lock()
+	defer self.access.Unlock()
+	if rank > self.Registers[index] {
+		self.Registers[index] = rank
+	}
+}
+
+// Merge adds all keys counted by another sketch of the same precision.
+func (self *Sketch) Merge(other *Sketch) error {
+	if other.Precision != self.Precision || len(other.Registers) != len(self.Registers) {
+		return fmt.Errorf("can not merge hyperloglog sketches of precision %d and %d", self.Precision, other.Precision)
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	for i, rank := range other.Registers {
+		if rank > self.Registers[i] {
+			self.Registers[i] = rank
+		}
+	}
+	return nil
+}
+
+// Count returns the estimated number of distinct keys.
+func (self *Sketch) Count() uint {
+	self.access.Lock()
+	defer self.access.Unlock()
+	m := float64(len(self.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range self.Registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(self.Registers)) * m * m / sum
+	// small range correction
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint(estimate + 0.5)
+}
+
+// Error returns the relative standard error of the estimate.
+func (self *Sketch) Error() float64 {
+	return Error(self.Precision)
+}
+
+// Error returns the relative standard error of sketches with the given precision.
+func Error(precision uint) float64 {
+	return 1.04 / math.Sqrt(float64(uint(1)<<precision))
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
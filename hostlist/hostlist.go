@@ -11,10 +11,10 @@ import (
 type Host struct {
 	Access    sync.Mutex
 	Name      string
-	Domains   []string
 	IPs       []net.IP
 	Glue      []net.IP
 	IsTldHost bool
+	Resolved  bool
 }
 
 type Hostlist struct {
@@ -52,21 +52,27 @@ func (self *Hostlist) AddHost(hostname string) *Host {
 	self.Access.Lock()
 	defer self.Access.Unlock()
 	if _, ok := self.List[hostname]; !ok {
-		self.List[hostname] = &Host{Name: hostname, Domains: nil, IPs: nil, Glue: nil, IsTldHost: strings.HasSuffix(hostname, self.Origin)}
+		self.List[hostname] = &Host{Name: hostname, IPs: nil, Glue: nil, IsTldHost: strings.HasSuffix(hostname, self.Origin)}
 	}
 	return self.List[hostname]
 }
 
-func (self *Host) GetName() string {
+// GetOrAdd returns a host and adds it first if it is not in the list yet.
+// Added is only true for the one caller that added the host.
+func (self *Hostlist) GetOrAdd(hostname string) (host *Host, added bool) {
 	self.Access.Lock()
 	defer self.Access.Unlock()
-	return self.Name
+	if host, ok := self.List[hostname]; ok {
+		return host, false
+	}
+	self.List[hostname] = &Host{Name: hostname, IPs: nil, Glue: nil, IsTldHost: strings.HasSuffix(hostname, self.Origin)}
+	return self.List[hostname], true
 }
 
-func (self *Host) AddDomain(domain string) {
+func (self *Host) GetName() string {
 	self.Access.Lock()
 	defer self.Access.Unlock()
-	self.Domains = append(self.Domains, domain)
+	return self.Name
 }
 
 func (self *Host) AddIP(ip net.IP) {
@@ -75,8 +81,40 @@ func (self *Host) AddIP(ip net.IP) {
 	self.IPs = append(self.IPs, ip)
 }
 
+// SetResolved marks a host as resolved, its IPs are complete.
+func (self *Host) SetResolved() {
+	self.Access.Lock()
+	defer self.Access.Unlock()
+	self.Resolved = true
+}
+
 func (self *Host) AddGlue(glue net.IP) {
 	self.Access.Lock()
 	defer self.Access.Unlock()
 	self.Glue = append(self.Glue, glue)
 }
+
+// Merge adds the addresses of the same host collected elsewhere (e.g. by another shard).
+// Addresses already known are not added again.
+func (self *Host) Merge(ips []net.IP, glue []net.IP) {
+	self.Access.Lock()
+	defer self.Access.Unlock()
+	self.IPs = mergeIPs(self.IPs, ips)
+	self.Glue = mergeIPs(self.Glue, glue)
+}
+
+func mergeIPs(list []net.IP, ips []net.IP) []net.IP {
+	for _, ip := range ips {
+		found := false
+		for _, listip := range list {
+			if ip.Equal(listip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, ip)
+		}
+	}
+	return list
+}
@@ -6,9 +6,12 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/progress"
 )
 
-func GetZone(zone string, server string, port uint) <-chan dns.RR {
+// GetZone transfers a zone. If progress is not nil the (uncompressed)
+// wire size of all records is counted as bytes transferred.
+func GetZone(zone string, server string, port uint, progress *progress.Progress) <-chan dns.RR {
 
 	// Setting up transfer
 	transfer := &dns.Transfer{DialTimeout: 5 * time.Second, ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
@@ -43,6 +46,9 @@ func GetZone(zone string, server string, port uint) <-chan dns.RR {
 				panic(env.Error)
 			}
 			for _, rr := range env.RR {
+				if progress != nil {
+					progress.AddBytes(dns.Len(rr))
+				}
 				c <- rr
 			}
 		}
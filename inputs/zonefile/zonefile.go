@@ -1,23 +1,34 @@
 package zonefile
 
 import (
+	"io"
 	"os"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/progress"
 )
 
-func GetZone(infile string, zone string) <-chan dns.RR {
+// GetZone reads a zone file. If progress is not nil the bytes read are counted.
+func GetZone(infile string, zone string, progress *progress.Progress) <-chan dns.RR {
 	// open zone file
 	f, err := os.Open(infile)
 	if err != nil {
 		panic(err)
 	}
+	var r io.Reader = f
+	if progress != nil {
+		info, err := f.Stat()
+		if err != nil {
+			panic(err)
+		}
+		r = progress.Reader(f, info.Size())
+	}
 
 	// prepare output channel
 	out := make(chan dns.RR, 10000)
 
 	// start zone file parsing
-	tokens := dns.ParseZone(f, dns.Fqdn(zone), infile)
+	tokens := dns.ParseZone(r, dns.Fqdn(zone), infile)
 
 	// translate tokens to RR and write to output channel
 	go func() {
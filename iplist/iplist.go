@@ -2,6 +2,7 @@ package iplist
 
 import (
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"net"
 	"strconv"
@@ -33,6 +34,7 @@ type IPlist struct {
 	Access  sync.Mutex
 	List    []*net.IP
 	Results []IpCap
+	Offline bool
 }
 
 func (self *IPlist) Init() {
@@ -59,6 +61,10 @@ func (self *IPlist) AddIP(ip net.IP, wg *sync.WaitGroup) {
 
 func (self *IPlist) Capability(ip net.IP, wg *sync.WaitGroup) {
 	defer wg.Done()
+	if self.Offline {
+		// no servers are tested in offline mode
+		return
+	}
 	edns0, bind, nsid, dnscookies, err := TestServer(ip.String())
 	if err != nil {
 		panic(err)
@@ -113,14 +119,14 @@ func TestServer(server string) (edns0 bool, bind string, nsid string, dnscookies
 	//	panic(err)
 	//}
 	if r == nil {
-		fmt.Printf("Error: %s\n", err.Error())
+		slog.Debug("error testing server", "server", server, "error", err)
 		err = nil
 		return
 	}
 	err = nil
 
 	if r.Rcode == dns.RcodeNotImplemented {
-		fmt.Printf("Not Implemented\n")
+		slog.Debug("server does not implement version.bind", "server", server)
 		return
 	}
 	for _, answer := range r.Answer {
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Record is the JSON line written for every point. Points without
+// timestamp are stamped with the time they are archived.
+type Record struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// Archive appends every point as one JSON line to a local file.
+type Archive struct {
+	filename string
+}
+
+func New(filename string) *Archive {
+	return &Archive{filename: filename}
+}
+
+func (self *Archive) Name() string {
+	return outputs.ARCHIVE
+}
+
+func (self *Archive) Write(points []outputs.Point) error {
+	file, err := os.OpenFile(self.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	encoder := json.NewEncoder(file)
+	for _, point := range points {
+		record := Record{Measurement: point.Measurement, Tags: point.Tags, Fields: point.Fields, Time: point.Time}
+		if record.Time.IsZero() {
+			record.Time = now
+		}
+		err = encoder.Encode(record)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
@@ -0,0 +1,181 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// SCHEMA is the version of the JSON document and the CSV columns.
+// Increase whenever fields are removed or change their meaning.
+const SCHEMA = 2
+
+// COLUMNS of the CSV output
+var COLUMNS = []string{"schema", "zone", "source", "input", "serial", "time", "measurement", "tags", "field", "type", "value"}
+
+// Document is the JSON output of a run
+type Document struct {
+	Schema uint     `json:"schema"`
+	Run    Run      `json:"run"`
+	Points []Record `json:"points"`
+}
+
+// Run is the metadata of the run, serial is left out if unknown
+type Run struct {
+	Zone   string    `json:"zone"`
+	Source string    `json:"source"`
+	Input  string    `json:"input"`
+	Serial *int64    `json:"serial,omitempty"`
+	Time   time.Time `json:"time"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// Record is one point
+type Record struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+// Export writes the complete results of a run as JSON document or as CSV
+// to a file or to STDOUT. Points are sorted by measurement and tags, so the
+// output of equal results is equal.
+type Export struct {
+	format   string
+	filename string
+	run      outputs.Run
+}
+
+// New returns an export in format (json or csv). Without filename the
+// results are written to STDOUT.
+func New(format string, filename string) *Export {
+	return &Export{format: format, filename: filename}
+}
+
+func (self *Export) Name() string {
+	return self.format
+}
+
+func (self *Export) SetRun(run outputs.Run) {
+	self.run = run
+}
+
+func (self *Export) Write(points []outputs.Point) error {
+	points = Sort(points)
+	if len(self.filename) == 0 {
+		return self.write(os.Stdout, points)
+	}
+	file, err := os.Create(self.filename)
+	if err != nil {
+		return err
+	}
+	err = self.write(file, points)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (self *Export) write(out io.Writer, points []outputs.Point) error {
+	if self.format == outputs.CSV {
+		return self.writeCSV(out, points)
+	}
+	return self.writeJSON(out, points)
+}
+
+func (self *Export) writeJSON(out io.Writer, points []outputs.Point) error {
+	document := Document{Schema: SCHEMA, Points: make([]Record, 0, len(points))}
+	document.Run = Run{
+		Zone:   self.run.Zone,
+		Source: self.run.Source,
+		Input:  self.run.Input,
+		Time:   self.run.Time,
+		Start:  self.run.Start,
+		End:    self.run.End,
+	}
+	if self.run.Serial >= 0 {
+		document.Run.Serial = &self.run.Serial
+	}
+	for _, point := range points {
+		document.Points = append(document.Points, Record{Measurement: point.Measurement, Tags: point.Tags, Fields: point.Fields, Time: point.Time})
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+// writeCSV writes one row per field. Tags are written in one column as
+// JSON object, type is int, float, bool or string.
+func (self *Export) writeCSV(out io.Writer, points []outputs.Point) error {
+	writer := csv.NewWriter(out)
+	writer.Write(COLUMNS)
+	serial := ""
+	if self.run.Serial >= 0 {
+		serial = strconv.FormatInt(self.run.Serial, 10)
+	}
+	for _, point := range points {
+		for _, field := range outputs.FieldKeys(point.Fields) {
+			kind, value := format(point.Fields[field])
+			writer.Write([]string{
+				strconv.Itoa(SCHEMA),
+				self.run.Zone,
+				self.run.Source,
+				self.run.Input,
+				serial,
+				point.Time.Format(time.RFC3339Nano),
+				point.Measurement,
+				Tags(point.Tags),
+				field,
+				kind,
+				value,
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// format returns the kind and the value of a field as strings
+func format(value interface{}) (string, string) {
+	kind, v := outputs.Value(value)
+	switch kind {
+	case outputs.INT:
+		return kind, strconv.FormatInt(v.(int64), 10)
+	case outputs.FLOAT:
+		return kind, strconv.FormatFloat(v.(float64), 'g', -1, 64)
+	case outputs.BOOL:
+		return kind, strconv.FormatBool(v.(bool))
+	}
+	return kind, v.(string)
+}
+
+// Tags returns the tags as JSON object with the names sorted, names and
+// values may contain any character
+func Tags(tags map[string]string) string {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	encoded, _ := json.Marshal(tags)
+	return string(encoded)
+}
+
+// Sort returns the points sorted by measurement and tags
+func Sort(points []outputs.Point) []outputs.Point {
+	sorted := append([]outputs.Point(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Measurement != sorted[j].Measurement {
+			return sorted[i].Measurement < sorted[j].Measurement
+		}
+		return Tags(sorted[i].Tags) < Tags(sorted[j].Tags)
+	})
+	return sorted
+}
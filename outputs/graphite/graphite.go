@@ -0,0 +1,155 @@
+package graphite
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// TEMPLATE is the default template of metric paths. <measurement>, <field>
+// and <tagname> are replaced by the measurement name, the field name and
+// the value of the tag.
+const TEMPLATE = "zonestats.<tld>.<measurement>.<field>"
+
+// TIMEOUT of connecting and writing to the server
+const TIMEOUT = 30 * time.Second
+
+// PACKETSIZE is the maximum size of StatsD datagrams
+const PACKETSIZE = 1432
+
+// Graphite flattens points into dotted metric paths. They are written
+// with the plaintext protocol to Carbon over TCP or as StatsD gauges
+// over UDP.
+type Graphite struct {
+	address  string
+	template string
+	statsd   bool
+}
+
+// New returns a sink writing to Carbon.
+func New(address string, template string) *Graphite {
+	if len(template) == 0 {
+		template = TEMPLATE
+	}
+	return &Graphite{address: address, template: template}
+}
+
+// NewStatsd returns a sink sending StatsD gauges.
+func NewStatsd(address string, template string) *Graphite {
+	self := New(address, template)
+	self.statsd = true
+	return self
+}
+
+func (self *Graphite) Name() string {
+	if self.statsd {
+		return outputs.STATSD
+	}
+	return outputs.GRAPHITE
+}
+
+func (self *Graphite) Write(points []outputs.Point) error {
+	if self.statsd {
+		return self.send(points)
+	}
+
+	conn, err := net.DialTimeout("tcp", self.address, TIMEOUT)
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(TIMEOUT))
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, point := range points {
+		timestamp := point.Time
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		for _, field := range outputs.FieldKeys(point.Fields) {
+			value, ok := outputs.Number(point.Fields[field])
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s %s %d\n", self.Path(point, field), strconv.FormatFloat(value, 'f', -1, 64), timestamp.Unix())
+		}
+	}
+	_, err = conn.Write(buf.Bytes())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return conn.Close()
+}
+
+// send writes all numeric fields as gauges, as many as fit into one datagram
+func (self *Graphite) send(points []outputs.Point) error {
+	conn, err := net.DialTimeout("udp", self.address, TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet bytes.Buffer
+	for _, point := range points {
+		for _, field := range outputs.FieldKeys(point.Fields) {
+			value, ok := outputs.Number(point.Fields[field])
+			if !ok {
+				continue
+			}
+			path := self.Path(point, field)
+			metric := fmt.Sprintf("%s:%s|g\n", path, strconv.FormatFloat(value, 'f', -1, 64))
+			// a signed value changes a gauge, so negative gauges are set to 0 first
+			if value < 0 {
+				metric = fmt.Sprintf("%s:0|g\n", path) + metric
+			}
+			if packet.Len() > 0 && packet.Len()+len(metric) > PACKETSIZE {
+				_, err = conn.Write(packet.Bytes())
+				if err != nil {
+					return err
+				}
+				packet.Reset()
+			}
+			packet.WriteString(metric)
+		}
+	}
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// Path returns the metric path of a field of a point. Placeholders of tags
+// the point does not have are left out, tags which are not used in the
+// template are not written. The root dot of the tld is removed.
+func (self *Graphite) Path(point outputs.Point, field string) string {
+	path := strings.Replace(self.template, "<measurement>", self.component(point.Measurement), -1)
+	path = strings.Replace(path, "<field>", self.component(field), -1)
+	for key, value := range point.Tags {
+		if key == "tld" && value != "." {
+			value = strings.TrimSuffix(value, ".")
+		}
+		path = strings.Replace(path, "<"+key+">", self.component(value), -1)
+	}
+	components := make([]string, 0)
+	for _, component := range strings.Split(path, ".") {
+		if !strings.HasPrefix(component, "<") || !strings.HasSuffix(component, ">") {
+			components = append(components, component)
+		}
+	}
+	return strings.Join(components, ".")
+}
+
+var cleanCarbon = strings.NewReplacer(".", "_", " ", "_", ";", "_", "=", "_", "~", "_", "\n", "_")
+var cleanStatsd = strings.NewReplacer(".", "_", " ", "_", ":", "_", "|", "_", "@", "_", "#", "_", "\n", "_")
+
+// component replaces all characters which would split a path component
+func (self *Graphite) component(name string) string {
+	if self.statsd {
+		return cleanStatsd.Replace(name)
+	}
+	return cleanCarbon.Replace(name)
+}
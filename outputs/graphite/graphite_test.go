@@ -0,0 +1,30 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+func TestPath(t *testing.T) {
+	point := outputs.Point{
+		Measurement: "CountDom",
+		Tags:        map[string]string{"tld": "se.", "source": "file", "collector": "a.example"},
+	}
+	tests := []struct {
+		sink *Graphite
+		want string
+	}{
+		{New("", ""), "zonestats.se.CountDom.value"},
+		{NewStatsd("", ""), "zonestats.se.CountDom.value"},
+		{New("", "zonestats.<tld>.<source>.<measurement>.<field>"), "zonestats.se.file.CountDom.value"},
+		{New("", "<collector>.<tld>.<measurement>.<field>"), "a_example.se.CountDom.value"},
+		{New("", "zonestats.<tld>.<shard>.<measurement>.<field>"), "zonestats.se.CountDom.value"},
+	}
+	for _, test := range tests {
+		got := test.sink.Path(point, "value")
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.sink.template, got, test.want)
+		}
+	}
+}
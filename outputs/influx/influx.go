@@ -0,0 +1,258 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/outputs/lineprotocol"
+)
+
+// v1 names of the precisions
+var v1precision = map[string]string{"ns": "n", "us": "u", "ms": "ms", "s": "s"}
+
+// Defaults of the delivery
+const (
+	// BATCH is the number of lines sent in one request
+	BATCH = 5000
+	// RETRIES is the number of times a failed request is repeated
+	RETRIES = 3
+	// BACKOFF is the wait before the first retry, it doubles with every retry
+	BACKOFF = time.Second
+	// TIMEOUT of one request
+	TIMEOUT = 30 * time.Second
+)
+
+// Config of the InfluxDB server to write to. Version 1 writes to a database
+// with basic authentication, version 2 writes to a bucket with token
+// authentication (InfluxDB 2.x and 3.x). Batches which can not be delivered
+// are saved to the spool directory and sent again on the next run.
+type Config struct {
+	Version   uint
+	Server    string
+	DB        string
+	User      string
+	Passwd    string
+	Org       string
+	Bucket    string
+	Token     string
+	Precision string
+	Batch     uint
+	Gzip      bool
+	Retries   uint
+	Spool     string
+}
+
+// Influx writes points in line protocol to InfluxDB. In a dry run the
+// requests are printed to STDOUT instead of being sent.
+type Influx struct {
+	config Config
+	dryrun bool
+	client *http.Client
+	sleep  func(time.Duration)
+}
+
+func New(config Config, dryrun bool) *Influx {
+	if config.Batch == 0 {
+		config.Batch = BATCH
+	}
+	if config.Retries == 0 {
+		config.Retries = RETRIES
+	}
+	return &Influx{config: config, dryrun: dryrun, client: &http.Client{Timeout: TIMEOUT}, sleep: time.Sleep}
+}
+
+func (self *Influx) Name() string {
+	return outputs.INFLUX
+}
+
+// statusError is returned if InfluxDB does not accept a request.
+type statusError struct {
+	status  string
+	code    int
+	message string
+}
+
+func (self *statusError) Error() string {
+	return fmt.Sprintf("InfluxDB answered %s: %s", self.status, self.message)
+}
+
+// transient errors are worth retrying
+func transient(err error) bool {
+	status, ok := err.(*statusError)
+	return !ok || status.code == http.StatusTooManyRequests || status.code >= 500
+}
+
+// rejected batches can never be written, because their data is invalid
+func rejected(err error) bool {
+	status, ok := err.(*statusError)
+	return ok && (status.code == http.StatusBadRequest || status.code == http.StatusRequestEntityTooLarge)
+}
+
+// Write sends the points in batches. Batches spooled by earlier runs are
+// sent first. Once InfluxDB can not be reached, all remaining batches are
+// spooled without trying. The last error of the batches of this run is
+// returned, spooled batches which fail again stay in the spool directory.
+func (self *Influx) Write(points []outputs.Point) error {
+	batches := make([][]byte, 0)
+	batch := make([]byte, 0)
+	lines := uint(0)
+	for _, point := range points {
+		var err error
+		batch, err = lineprotocol.Append(batch, point, outputs.PRECISIONS[self.config.Precision])
+		if err != nil {
+			slog.Error("point can not be written to InfluxDB", "measurement", point.Measurement, "error", err)
+			continue
+		}
+		lines++
+		if lines == self.config.Batch {
+			batches = append(batches, batch)
+			batch = make([]byte, 0)
+			lines = 0
+		}
+	}
+	if lines > 0 {
+		batches = append(batches, batch)
+	}
+
+	if self.dryrun {
+		slog.Info("DRYRUN! No actual call to InfluxDB has been made. The following calls would have been made without --dryrun")
+		for _, body := range batches {
+			req, err := self.request(bytes.NewReader(body), self.config.Precision, false)
+			if err != nil {
+				return err
+			}
+			requestDump, err := httputil.DumpRequest(req, true)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(requestDump))
+		}
+		return nil
+	}
+
+	// errors of the replay are not errors of this run, but if InfluxDB can
+	// not be reached the batches of this run are spooled with that error
+	var unreachable error
+	if len(self.config.Spool) > 0 {
+		err := self.replay()
+		if err != nil && transient(err) {
+			unreachable = err
+		} else if err != nil {
+			slog.Error("spooled batches not sent", "error", err)
+		}
+	}
+	var last error
+	down := unreachable != nil
+	for i, body := range batches {
+		err := unreachable
+		if !down {
+			err = self.send(body, self.config.Precision)
+			if err == nil {
+				continue
+			}
+			down = transient(err)
+			if down {
+				unreachable = err
+			}
+		}
+		last = err
+		if rejected(err) || len(self.config.Spool) == 0 {
+			slog.Error("batch not written to InfluxDB", "batch", i, "lines", bytes.Count(body, []byte("\n")), "error", err)
+			continue
+		}
+		spooled, err := self.spool(body, self.config.Precision)
+		if err != nil {
+			slog.Error("batch can not be spooled", "batch", i, "error", err)
+			continue
+		}
+		slog.Warn("batch spooled to be sent on the next run", "batch", i, "file", spooled)
+	}
+	return last
+}
+
+// send posts one batch, retrying transient errors with backoff
+func (self *Influx) send(body []byte, precision string) error {
+	if self.config.Gzip {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(body)
+		writer.Close()
+		body = compressed.Bytes()
+	}
+	backoff := BACKOFF
+	var err error
+	for attempt := uint(0); ; attempt++ {
+		err = self.post(body, precision)
+		if err == nil || !transient(err) || attempt == self.config.Retries {
+			return err
+		}
+		slog.Warn("writing to InfluxDB failed, retrying", "error", err, "retry", attempt+1, "wait", backoff)
+		self.sleep(backoff)
+		backoff = 2 * backoff
+	}
+}
+
+// post sends one request and checks the answer
+func (self *Influx) post(body []byte, precision string) error {
+	req, err := self.request(bytes.NewReader(body), precision, self.config.Gzip)
+	if err != nil {
+		return err
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{status: resp.Status, code: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+	return nil
+}
+
+// request returns the write request for the API version configured
+func (self *Influx) request(body io.Reader, precision string, compressed bool) (*http.Request, error) {
+	sessionurl, err := url.Parse(self.config.Server)
+	if err != nil {
+		return nil, err
+	}
+	q := sessionurl.Query()
+	if self.config.Version == 2 {
+		sessionurl.Path = "/api/v2/write"
+		if len(self.config.Org) > 0 {
+			q.Set("org", self.config.Org)
+		}
+		q.Set("bucket", self.config.Bucket)
+		q.Set("precision", precision)
+	} else {
+		sessionurl.Path = "write"
+		q.Set("db", self.config.DB)
+		q.Set("precision", v1precision[precision])
+	}
+	sessionurl.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, sessionurl.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if self.config.Version == 2 {
+		req.Header.Set("Authorization", "Token "+self.config.Token)
+	} else if len(self.config.User) > 0 {
+		req.SetBasicAuth(self.config.User, self.config.Passwd)
+	}
+	return req, nil
+}
@@ -0,0 +1,284 @@
+package influx
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// request is a request received by the test server
+type request struct {
+	path   string
+	query  map[string]string
+	header http.Header
+	body   string
+}
+
+// server answers requests with the status codes given, the last one is
+// repeated
+type server struct {
+	*httptest.Server
+	access   sync.Mutex
+	codes    []int
+	requests []request
+}
+
+func newServer(t *testing.T, codes ...int) *server {
+	self := &server{codes: codes}
+	self.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = reader
+		}
+		data, _ := io.ReadAll(body)
+		query := make(map[string]string)
+		for key := range r.URL.Query() {
+			query[key] = r.URL.Query().Get(key)
+		}
+		self.access.Lock()
+		self.requests = append(self.requests, request{path: r.URL.Path, query: query, header: r.Header, body: string(data)})
+		code := self.codes[0]
+		if len(self.codes) > 1 {
+			self.codes = self.codes[1:]
+		}
+		self.access.Unlock()
+		w.WriteHeader(code)
+		if code >= 300 {
+			w.Write([]byte(`{"error":"test"}`))
+		}
+	}))
+	t.Cleanup(self.Close)
+	return self
+}
+
+// testInflux returns a writer to the server, which records its waits
+// instead of sleeping
+func testInflux(config Config, waits *[]time.Duration) *Influx {
+	influx := New(config, false)
+	influx.sleep = func(wait time.Duration) { *waits = append(*waits, wait) }
+	return influx
+}
+
+func points(n int) []outputs.Point {
+	list := make([]outputs.Point, 0, n)
+	for i := 0; i < n; i++ {
+		point := outputs.NewPoint("CountDom", "se.", "file")
+		point.Fields["value"] = int64(i)
+		point.Time = time.Unix(1700000000, 0)
+		list = append(list, point)
+	}
+	return list
+}
+
+func TestWriteV1(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent)
+	var waits []time.Duration
+	influx := testInflux(Config{Version: 1, Server: srv.URL, DB: "zonestats", User: "user", Passwd: "secret", Precision: "s"}, &waits)
+	if err := influx.Write(points(2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 1 {
+		t.Fatalf("%d requests", len(srv.requests))
+	}
+	req := srv.requests[0]
+	if req.path != "/write" || !reflect.DeepEqual(req.query, map[string]string{"db": "zonestats", "precision": "s"}) {
+		t.Errorf("request to %s %v", req.path, req.query)
+	}
+	if user, passwd, ok := (&http.Request{Header: req.header}).BasicAuth(); !ok || user != "user" || passwd != "secret" {
+		t.Errorf("basic authentication %q %q", user, passwd)
+	}
+	want := "CountDom,source=file,tld=se. value=0i 1700000000\nCountDom,source=file,tld=se. value=1i 1700000000\n"
+	if req.body != want {
+		t.Errorf("body %q, want %q", req.body, want)
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent)
+	var waits []time.Duration
+	influx := testInflux(Config{Version: 2, Server: srv.URL, Org: "org", Bucket: "bucket", Token: "token", Precision: "ms", Gzip: true}, &waits)
+	if err := influx.Write(points(1)); err != nil {
+		t.Fatal(err)
+	}
+	req := srv.requests[0]
+	if req.path != "/api/v2/write" || !reflect.DeepEqual(req.query, map[string]string{"org": "org", "bucket": "bucket", "precision": "ms"}) {
+		t.Errorf("request to %s %v", req.path, req.query)
+	}
+	if req.header.Get("Authorization") != "Token token" {
+		t.Errorf("authorization %q", req.header.Get("Authorization"))
+	}
+	if req.body != "CountDom,source=file,tld=se. value=0i 1700000000000\n" {
+		t.Errorf("body %q", req.body)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	srv := newServer(t, http.StatusNoContent)
+	var waits []time.Duration
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s", Batch: 2}, &waits)
+	if err := influx.Write(points(5)); err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]int, 0)
+	for _, req := range srv.requests {
+		lines = append(lines, strings.Count(req.body, "\n"))
+	}
+	if !reflect.DeepEqual(lines, []int{2, 2, 1}) {
+		t.Errorf("batches of %v lines", lines)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	srv := newServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusNoContent)
+	var waits []time.Duration
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s"}, &waits)
+	if err := influx.Write(points(1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 4 {
+		t.Errorf("%d requests, want 4", len(srv.requests))
+	}
+	if !reflect.DeepEqual(waits, []time.Duration{BACKOFF, 2 * BACKOFF, 4 * BACKOFF}) {
+		t.Errorf("waits %v", waits)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	srv := newServer(t, http.StatusBadGateway)
+	var waits []time.Duration
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s", Retries: 2}, &waits)
+	err := influx.Write(points(1))
+	if status, ok := err.(*statusError); !ok || status.code != http.StatusBadGateway {
+		t.Fatalf("error %v", err)
+	}
+	if len(srv.requests) != 3 {
+		t.Errorf("%d requests, want 3", len(srv.requests))
+	}
+}
+
+func TestRejected(t *testing.T) {
+	srv := newServer(t, http.StatusBadRequest)
+	spool := t.TempDir()
+	var waits []time.Duration
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s", Spool: spool}, &waits)
+	if err := influx.Write(points(1)); err == nil {
+		t.Fatal("rejected batch without error")
+	}
+	if len(srv.requests) != 1 || len(waits) != 0 {
+		t.Errorf("%d requests, %d retries", len(srv.requests), len(waits))
+	}
+	if files, _ := os.ReadDir(spool); len(files) != 0 {
+		t.Errorf("rejected batch spooled")
+	}
+}
+
+func spoolFiles(t *testing.T, spool string, ext string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(spool, "*"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpoolReplay(t *testing.T) {
+	spool := t.TempDir()
+	var waits []time.Duration
+
+	// InfluxDB is down, all batches are spooled and only the first is tried
+	down := newServer(t, http.StatusServiceUnavailable)
+	influx := testInflux(Config{Server: down.URL, DB: "zonestats", Precision: "s", Batch: 1, Retries: 1, Spool: spool}, &waits)
+	if err := influx.Write(points(2)); err == nil {
+		t.Fatal("no error while InfluxDB is down")
+	}
+	if len(down.requests) != 2 {
+		t.Errorf("%d requests while down, want 2", len(down.requests))
+	}
+	if files := spoolFiles(t, spool, SPOOLED); len(files) != 2 {
+		t.Fatalf("%d batches spooled, want 2", len(files))
+	}
+
+	// spooled batches are sent first, in order
+	up := newServer(t, http.StatusNoContent)
+	influx = testInflux(Config{Server: up.URL, DB: "zonestats", Precision: "s", Batch: 1, Spool: spool}, &waits)
+	point := outputs.NewPoint("CountRR", "se.", "file")
+	point.Fields["NS"] = int64(1)
+	if err := influx.Write([]outputs.Point{point}); err != nil {
+		t.Fatal(err)
+	}
+	bodies := make([]string, 0)
+	for _, req := range up.requests {
+		bodies = append(bodies, req.body)
+	}
+	want := []string{
+		"CountDom,source=file,tld=se. value=0i 1700000000\n",
+		"CountDom,source=file,tld=se. value=1i 1700000000\n",
+		"CountRR,source=file,tld=se. NS=1i\n",
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("requests %q", bodies)
+	}
+	if files := spoolFiles(t, spool, SPOOLED); len(files) != 0 {
+		t.Errorf("%d batches left in spool", len(files))
+	}
+}
+
+func TestReplayRejected(t *testing.T) {
+	spool := t.TempDir()
+	var waits []time.Duration
+	srv := newServer(t, http.StatusBadRequest, http.StatusNoContent)
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s", Spool: spool}, &waits)
+	if _, err := influx.spool([]byte("invalid\n"), "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rejected spooled batch is not an error of this run
+	if err := influx.Write(points(1)); err != nil {
+		t.Fatalf("error %v", err)
+	}
+	if len(srv.requests) != 2 || srv.requests[0].body != "invalid\n" {
+		t.Errorf("requests %v", srv.requests)
+	}
+	if files := spoolFiles(t, spool, SPOOLED); len(files) != 0 {
+		t.Errorf("%d batches left in spool", len(files))
+	}
+	if files := spoolFiles(t, spool, REJECTED); len(files) != 1 {
+		t.Errorf("%d batches rejected, want 1", len(files))
+	}
+}
+
+func TestReplayDown(t *testing.T) {
+	spool := t.TempDir()
+	var waits []time.Duration
+	srv := newServer(t, http.StatusServiceUnavailable)
+	influx := testInflux(Config{Server: srv.URL, DB: "zonestats", Precision: "s", Retries: 1, Spool: spool}, &waits)
+	if _, err := influx.spool([]byte("CountDom value=1i\n"), "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the batch of this run is spooled without trying
+	if err := influx.Write(points(1)); err == nil {
+		t.Fatal("no error while InfluxDB is down")
+	}
+	if len(srv.requests) != 2 {
+		t.Errorf("%d requests, want 2", len(srv.requests))
+	}
+	if files := spoolFiles(t, spool, SPOOLED); len(files) != 2 {
+		t.Errorf("%d batches spooled, want 2", len(files))
+	}
+}
@@ -0,0 +1,88 @@
+package influx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Spooled batches are stored as <time>-<n>.<precision>.lp in the spool
+// directory. Batches InfluxDB rejects on replay are renamed to .rejected
+// and never sent again.
+const (
+	SPOOLED  = ".lp"
+	REJECTED = ".rejected"
+)
+
+var spooled int
+
+// spool saves a batch to be sent on the next run
+func (self *Influx) spool(body []byte, precision string) (string, error) {
+	err := os.MkdirAll(self.config.Spool, 0755)
+	if err != nil {
+		return "", err
+	}
+	spooled++
+	filename := filepath.Join(self.config.Spool, fmt.Sprintf("%d-%d.%s%s", time.Now().UnixNano(), spooled, precision, SPOOLED))
+
+	// write to a temporary file first, so replay never sees a partial batch
+	tmp, err := ioutil.TempFile(self.config.Spool, ".spool")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(body)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return filename, nil
+}
+
+// replay sends all spooled batches in the order they were spooled.
+// Batches which can never be sent are renamed and logged. It stops at the
+// first other batch which can not be sent and returns that error.
+func (self *Influx) replay() error {
+	filenames, _ := filepath.Glob(filepath.Join(self.config.Spool, "*"+SPOOLED))
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		body, err := ioutil.ReadFile(filename)
+		if err != nil {
+			slog.Error("spooled batch can not be read", "file", filename, "error", err)
+			continue
+		}
+		precision := strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(filename, SPOOLED)), ".")
+		if _, ok := outputs.PRECISIONS[precision]; !ok {
+			slog.Error("spooled batch has unknown precision", "file", filename, "precision", precision)
+			os.Rename(filename, strings.TrimSuffix(filename, SPOOLED)+REJECTED)
+			continue
+		}
+		err = self.send(body, precision)
+		if rejected(err) {
+			slog.Error("spooled batch rejected by InfluxDB", "file", filename, "error", err)
+			os.Rename(filename, strings.TrimSuffix(filename, SPOOLED)+REJECTED)
+			continue
+		}
+		if err != nil {
+			slog.Warn("spooled batches can not be sent", "error", err)
+			return err
+		}
+		slog.Info("spooled batch sent", "file", filename)
+		os.Remove(filename)
+	}
+	return nil
+}
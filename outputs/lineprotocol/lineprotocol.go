@@ -0,0 +1,130 @@
+package lineprotocol
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Escaping of the elements of a line. Measurement names escape commas and
+// spaces, tag keys, tag values and field keys also escape equal signs.
+// String field values escape double quotes and backslashes.
+var escapeMeasurement = strings.NewReplacer(",", "\\,", " ", "\\ ")
+var escapeKey = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+var escapeString = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+// Append appends a point as one line to buf. Tags and fields are sorted
+// by key, tags with empty values are left out. Integers are written as
+// integer fields, floats as float fields. The timestamp is written in
+// units of precision. If the point can not be written, buf is returned
+// unchanged with an error.
+func Append(buf []byte, point outputs.Point, precision time.Duration) ([]byte, error) {
+	start := len(buf)
+	if len(point.Measurement) == 0 {
+		return buf, errors.New("measurement name missing")
+	}
+	if err := check(point.Measurement); err != nil {
+		return buf, fmt.Errorf("measurement %s", err)
+	}
+	if point.Measurement[0] == '#' {
+		return buf, errors.New("measurement name must not start with #")
+	}
+	if len(point.Fields) == 0 {
+		return buf, fmt.Errorf("measurement %s without fields", point.Measurement)
+	}
+	buf = append(buf, escapeMeasurement.Replace(point.Measurement)...)
+
+	for _, key := range outputs.TagKeys(point.Tags) {
+		value := point.Tags[key]
+		if len(value) == 0 {
+			continue
+		}
+		if err := checkKey(key); err != nil {
+			return buf[:start], fmt.Errorf("tag %s", err)
+		}
+		if err := check(value); err != nil {
+			return buf[:start], fmt.Errorf("value of tag %s %s", key, err)
+		}
+		buf = append(buf, ',')
+		buf = append(buf, escapeKey.Replace(key)...)
+		buf = append(buf, '=')
+		buf = append(buf, escapeKey.Replace(value)...)
+	}
+
+	seperator := byte(' ')
+	for _, key := range outputs.FieldKeys(point.Fields) {
+		if err := checkKey(key); err != nil {
+			return buf[:start], fmt.Errorf("field %s", err)
+		}
+		buf = append(buf, seperator)
+		buf = append(buf, escapeKey.Replace(key)...)
+		buf = append(buf, '=')
+		var err error
+		buf, err = appendValue(buf, point.Fields[key])
+		if err != nil {
+			return buf[:start], fmt.Errorf("field %s: %s", key, err)
+		}
+		seperator = ','
+	}
+
+	if !point.Time.IsZero() {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, point.Time.UnixNano()/int64(precision), 10)
+	}
+	return append(buf, '\n'), nil
+}
+
+// Line returns a point as one line.
+func Line(point outputs.Point, precision time.Duration) (string, error) {
+	buf, err := Append(nil, point, precision)
+	return string(buf), err
+}
+
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	kind, v := outputs.Value(value)
+	switch kind {
+	case outputs.INT:
+		buf = strconv.AppendInt(buf, v.(int64), 10)
+		return append(buf, 'i'), nil
+	case outputs.FLOAT:
+		number := v.(float64)
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return buf, fmt.Errorf("float %g can not be written", number)
+		}
+		return strconv.AppendFloat(buf, number, 'g', -1, 64), nil
+	case outputs.BOOL:
+		return strconv.AppendBool(buf, v.(bool)), nil
+	}
+	if strings.ContainsAny(v.(string), "\n\r") {
+		return buf, errors.New("contains a line break")
+	}
+	buf = append(buf, '"')
+	buf = append(buf, escapeString.Replace(v.(string))...)
+	return append(buf, '"'), nil
+}
+
+// check rejects what can not be escaped
+func check(str string) error {
+	if strings.ContainsAny(str, "\n\r") {
+		return errors.New("contains a line break")
+	}
+	if strings.HasSuffix(str, "\\") {
+		return errors.New("ends with a backslash")
+	}
+	return nil
+}
+
+func checkKey(key string) error {
+	if len(key) == 0 {
+		return errors.New("key missing")
+	}
+	if err := check(key); err != nil {
+		return fmt.Errorf("key %s %s", key, err)
+	}
+	return nil
+}
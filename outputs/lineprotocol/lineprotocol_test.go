@@ -0,0 +1,99 @@
+package lineprotocol
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+func point(measurement string, tags map[string]string, fields map[string]interface{}) outputs.Point {
+	return outputs.Point{Measurement: measurement, Tags: tags, Fields: fields, Time: time.Unix(1700000000, 0)}
+}
+
+func TestEscaping(t *testing.T) {
+	tests := []struct {
+		name  string
+		point outputs.Point
+		line  string
+	}{
+		{"plain", point("CountDom", map[string]string{"tld": "se."}, map[string]interface{}{"value": int64(1)}),
+			`CountDom,tld=se. value=1i 1700000000`},
+		{"measurement space and comma", point("Count Dom,x=y", nil, map[string]interface{}{"value": int64(1)}),
+			`Count\ Dom\,x=y value=1i 1700000000`},
+		{"tag space", point("m", map[string]string{"tag key": "a value"}, map[string]interface{}{"f": int64(1)}),
+			`m,tag\ key=a\ value f=1i 1700000000`},
+		{"tag comma", point("m", map[string]string{"a,b": "c,d"}, map[string]interface{}{"f": int64(1)}),
+			`m,a\,b=c\,d f=1i 1700000000`},
+		{"tag equal sign", point("m", map[string]string{"a=b": "c=d"}, map[string]interface{}{"f": int64(1)}),
+			`m,a\=b=c\=d f=1i 1700000000`},
+		{"tag quote", point("m", map[string]string{"t": `"quoted"`}, map[string]interface{}{"f": int64(1)}),
+			`m,t="quoted" f=1i 1700000000`},
+		{"tag backslash", point("m", map[string]string{"t": `a\b`}, map[string]interface{}{"f": int64(1)}),
+			`m,t=a\b f=1i 1700000000`},
+		{"empty tag left out", point("m", map[string]string{"a": "", "b": "x"}, map[string]interface{}{"f": int64(1)}),
+			`m,b=x f=1i 1700000000`},
+		{"field key", point("m", nil, map[string]interface{}{"a b,c=d": int64(1)}),
+			`m a\ b\,c\=d=1i 1700000000`},
+		{"string field quote and backslash", point("m", nil, map[string]interface{}{"s": `say "hi" \o/`}),
+			`m s="say \"hi\" \\o/" 1700000000`},
+		{"string field space comma equal", point("m", nil, map[string]interface{}{"s": "a b,c=d"}),
+			`m s="a b,c=d" 1700000000`},
+		{"field types", point("m", nil, map[string]interface{}{"b": true, "f": 0.25, "i": int32(-3), "u": uint64(7)}),
+			`m b=true,f=0.25,i=-3i,u=7i 1700000000`},
+		{"sorted", point("m", map[string]string{"z": "1", "a": "2"}, map[string]interface{}{"y": int64(1), "b": int64(2)}),
+			`m,a=2,z=1 b=2i,y=1i 1700000000`},
+		{"unsigned beyond int64 as float", point("m", nil, map[string]interface{}{"u": uint64(math.MaxUint64)}),
+			`m u=1.8446744073709552e+19 1700000000`},
+		{"other types as string", point("m", nil, map[string]interface{}{"l": []int{1, 2}}),
+			`m l="[1 2]" 1700000000`},
+		{"no timestamp", outputs.Point{Measurement: "m", Fields: map[string]interface{}{"f": int64(1)}},
+			`m f=1i`},
+	}
+	for _, test := range tests {
+		line, err := Line(test.point, time.Second)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if line != test.line+"\n" {
+			t.Errorf("%s: line %q, want %q", test.name, line, test.line+"\n")
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		point outputs.Point
+		error string
+	}{
+		{"no measurement", point("", nil, map[string]interface{}{"f": int64(1)}), "measurement name missing"},
+		{"comment", point("#m", nil, map[string]interface{}{"f": int64(1)}), "must not start with #"},
+		{"no fields", point("m", nil, map[string]interface{}{}), "without fields"},
+		{"newline in measurement", point("a\nb", nil, map[string]interface{}{"f": int64(1)}), "line break"},
+		{"newline in tag value", point("m", map[string]string{"t": "a\nb"}, map[string]interface{}{"f": int64(1)}), "line break"},
+		{"carriage return in tag key", point("m", map[string]string{"a\rb": "v"}, map[string]interface{}{"f": int64(1)}), "line break"},
+		{"newline in field key", point("m", nil, map[string]interface{}{"a\nb": int64(1)}), "line break"},
+		{"newline in string field", point("m", nil, map[string]interface{}{"s": "a\nb"}), "line break"},
+		{"trailing backslash", point("m", map[string]string{"t": `a\`}, map[string]interface{}{"f": int64(1)}), "backslash"},
+		{"NaN", point("m", nil, map[string]interface{}{"f": math.NaN()}), "NaN"},
+		{"infinity", point("m", nil, map[string]interface{}{"f": math.Inf(1)}), "Inf"},
+	}
+	for _, test := range tests {
+		buf := []byte("before\n")
+		result, err := Append(buf, test.point, time.Second)
+		if err == nil {
+			t.Errorf("%s: accepted as %q", test.name, result)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: error %q, want %q", test.name, err, test.error)
+		}
+		if string(result) != "before\n" {
+			t.Errorf("%s: buffer changed to %q", test.name, result)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Defaults of the exporter
+const (
+	// PATH of the metrics endpoint, used if the url has no path
+	PATH = "/v1/metrics"
+	// SERVICE is the default service.name resource attribute
+	SERVICE = "zonestats"
+	// RETRIES is the number of times a failed export is repeated
+	RETRIES = 3
+	// BACKOFF is the wait before the first retry, it doubles with every retry
+	BACKOFF = time.Second
+	// TIMEOUT of one export
+	TIMEOUT = 30 * time.Second
+)
+
+// OTLP exports points as OpenTelemetry gauges with OTLP/HTTP in JSON
+// encoding. Every numeric field of a point becomes a data point of the
+// gauge zonestats.<measurement>.<field>, the tags become its attributes.
+type OTLP struct {
+	endpoint string
+	resource map[string]string
+	headers  map[string]string
+	client   *http.Client
+	sleep    func(time.Duration)
+}
+
+func New(endpoint string, resource map[string]string, headers map[string]string) *OTLP {
+	if u, err := url.Parse(endpoint); err == nil && (u.Path == "" || u.Path == "/") {
+		u.Path = PATH
+		endpoint = u.String()
+	}
+	attributes := map[string]string{"service.name": SERVICE}
+	for key, value := range resource {
+		attributes[key] = value
+	}
+	return &OTLP{endpoint: endpoint, resource: attributes, headers: headers, client: &http.Client{Timeout: TIMEOUT}, sleep: time.Sleep}
+}
+
+func (self *OTLP) Name() string {
+	return outputs.OTLP
+}
+
+// Messages of the OTLP JSON encoding. 64 bit integers are encoded as strings.
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type dataPoint struct {
+	Attributes   []keyValue `json:"attributes"`
+	TimeUnixNano string     `json:"timeUnixNano"`
+	AsInt        *string    `json:"asInt,omitempty"`
+	AsDouble     *float64   `json:"asDouble,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Gauge gauge  `json:"gauge"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+func attributes(tags map[string]string) []keyValue {
+	list := make([]keyValue, 0, len(tags))
+	for _, key := range outputs.TagKeys(tags) {
+		list = append(list, keyValue{Key: key, Value: anyValue{StringValue: tags[key]}})
+	}
+	return list
+}
+
+// Request returns the export request for the points. Points without
+// timestamp are stamped with the current time.
+func (self *OTLP) Request(points []outputs.Point) ([]byte, error) {
+	now := time.Now()
+	metrics := make(map[string]*metric)
+	names := make([]string, 0)
+	for _, point := range points {
+		timestamp := point.Time
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		for field, value := range point.Fields {
+			dp := dataPoint{Attributes: attributes(point.Tags), TimeUnixNano: strconv.FormatInt(timestamp.UnixNano(), 10)}
+			kind, v := outputs.Value(value)
+			switch kind {
+			case outputs.INT:
+				number := strconv.FormatInt(v.(int64), 10)
+				dp.AsInt = &number
+			case outputs.STRING:
+				continue
+			default:
+				number, _ := outputs.Number(value)
+				dp.AsDouble = &number
+			}
+			name := "zonestats." + point.Measurement + "." + field
+			if _, ok := metrics[name]; !ok {
+				metrics[name] = &metric{Name: name}
+				names = append(names, name)
+			}
+			metrics[name].Gauge.DataPoints = append(metrics[name].Gauge.DataPoints, dp)
+		}
+	}
+	sort.Strings(names)
+	list := make([]metric, 0, len(names))
+	for _, name := range names {
+		list = append(list, *metrics[name])
+	}
+	request := exportRequest{ResourceMetrics: []resourceMetrics{{
+		Resource:     resource{Attributes: attributes(self.resource)},
+		ScopeMetrics: []scopeMetrics{{Scope: scope{Name: SERVICE}, Metrics: list}},
+	}}}
+	return json.Marshal(request)
+}
+
+// Write exports all points in one request. Network errors and the
+// answers 429, 502, 503 and 504 are retried with backoff, a Retry-After
+// given by the receiver is respected.
+func (self *OTLP) Write(points []outputs.Point) error {
+	body, err := self.Request(points)
+	if err != nil {
+		return err
+	}
+	backoff := BACKOFF
+	for attempt := 0; ; attempt++ {
+		wait, err := self.post(body)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || attempt == RETRIES {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+		}
+		slog.Warn("OTLP export failed, retrying", "error", err, "retry", attempt+1, "wait", wait)
+		self.sleep(wait)
+		backoff = 2 * backoff
+	}
+}
+
+// post sends the request once. If it failed, wait is negative for
+// permanent errors, otherwise the time asked for by the receiver or 0.
+func (self *OTLP) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, self.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range self.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return 0, nil
+	}
+	err = fmt.Errorf("OTLP receiver answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, err
+	}
+	return -1, err
+}
@@ -0,0 +1,151 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// answer is a response of the test server
+type answer struct {
+	code       int
+	retryAfter string
+}
+
+// server answers with the responses given, the last one is repeated. It
+// returns the bodies and headers of all requests.
+func server(t *testing.T, answers ...answer) (*httptest.Server, *[]*http.Request, *[][]byte) {
+	requests := make([]*http.Request, 0)
+	bodies := make([][]byte, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		a := answers[0]
+		if len(answers) > 1 {
+			answers = answers[1:]
+		}
+		if len(a.retryAfter) > 0 {
+			w.Header().Set("Retry-After", a.retryAfter)
+		}
+		w.WriteHeader(a.code)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests, &bodies
+}
+
+// testOTLP returns an exporter which records its waits instead of sleeping
+func testOTLP(endpoint string, waits *[]time.Duration) *OTLP {
+	exporter := New(endpoint, map[string]string{"deployment.environment": "test"}, map[string]string{"X-Api-Key": "key"})
+	exporter.sleep = func(wait time.Duration) { *waits = append(*waits, wait) }
+	return exporter
+}
+
+func testPoints() []outputs.Point {
+	point := outputs.NewPoint("CountDom", "se.", "file")
+	point.Fields["value"] = int64(42)
+	point.Fields["error"] = 0.5
+	point.Fields["name"] = "not a number"
+	point.Time = time.Unix(1700000000, 0)
+	return []outputs.Point{point}
+}
+
+func TestExport(t *testing.T) {
+	srv, requests, bodies := server(t, answer{code: http.StatusOK})
+	var waits []time.Duration
+	if err := testOTLP(srv.URL, &waits).Write(testPoints()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.URL.Path != PATH || req.Header.Get("Content-Type") != "application/json" || req.Header.Get("X-Api-Key") != "key" {
+		t.Errorf("request to %s with %v", req.URL.Path, req.Header)
+	}
+	decoded := exportRequest{}
+	if err := json.Unmarshal((*bodies)[0], &decoded); err != nil {
+		t.Fatal(err)
+	}
+	resource := decoded.ResourceMetrics[0].Resource.Attributes
+	if len(resource) != 2 || resource[0].Key != "deployment.environment" || resource[1].Value.StringValue != SERVICE {
+		t.Errorf("resource %v", resource)
+	}
+	metrics := decoded.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	names := make([]string, 0)
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"zonestats.CountDom.error", "zonestats.CountDom.value"}) {
+		t.Fatalf("metrics %v", names)
+	}
+	value := metrics[1].Gauge.DataPoints[0]
+	if value.AsInt == nil || *value.AsInt != "42" || value.TimeUnixNano != "1700000000000000000" || len(value.Attributes) != 2 {
+		t.Errorf("data point %+v", value)
+	}
+	if e := metrics[0].Gauge.DataPoints[0].AsDouble; e == nil || *e != 0.5 {
+		t.Errorf("error %v", e)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	srv, requests, _ := server(t,
+		answer{code: http.StatusTooManyRequests, retryAfter: "7"},
+		answer{code: http.StatusServiceUnavailable},
+		answer{code: http.StatusBadGateway, retryAfter: "soon"},
+		answer{code: http.StatusOK})
+	var waits []time.Duration
+	if err := testOTLP(srv.URL, &waits).Write(testPoints()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*requests) != 4 {
+		t.Errorf("%d requests, want 4", len(*requests))
+	}
+	// Retry-After is respected, without (or with an invalid one) the backoff doubles
+	want := []time.Duration{7 * time.Second, 2 * BACKOFF, 4 * BACKOFF}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("waits %v, want %v", waits, want)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	srv, requests, _ := server(t, answer{code: http.StatusGatewayTimeout})
+	var waits []time.Duration
+	if err := testOTLP(srv.URL, &waits).Write(testPoints()); err == nil {
+		t.Fatal("no error")
+	}
+	if len(*requests) != RETRIES+1 || len(waits) != RETRIES {
+		t.Errorf("%d requests and %d waits", len(*requests), len(waits))
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusInternalServerError} {
+		srv, requests, _ := server(t, answer{code: code, retryAfter: "1"})
+		var waits []time.Duration
+		if err := testOTLP(srv.URL, &waits).Write(testPoints()); err == nil {
+			t.Errorf("%d: no error", code)
+		}
+		if len(*requests) != 1 || len(waits) != 0 {
+			t.Errorf("%d: %d requests, %d waits", code, len(*requests), len(waits))
+		}
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"http://collector:4318":             "http://collector:4318/v1/metrics",
+		"http://collector:4318/":            "http://collector:4318/v1/metrics",
+		"http://collector:4318/custom/otlp": "http://collector:4318/custom/otlp",
+	} {
+		if got := New(endpoint, nil, nil).endpoint; got != want {
+			t.Errorf("%s: endpoint %s, want %s", endpoint, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package outputs
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Sink types
+const (
+	// INFLUX writes to InfluxDB
+	INFLUX = "influx"
+	// ARCHIVE appends JSON lines to a local file
+	ARCHIVE = "archive"
+	// PROMETHEUS writes a file for the textfile collector of the node exporter
+	PROMETHEUS = "prometheus"
+	// PUSHGATEWAY pushes to a Prometheus Pushgateway
+	PUSHGATEWAY = "pushgateway"
+	// GRAPHITE writes to Carbon with the plaintext protocol
+	GRAPHITE = "graphite"
+	// STATSD sends StatsD gauges
+	STATSD = "statsd"
+	// OTLP exports to an OpenTelemetry collector
+	OTLP = "otlp"
+	// JSON writes the results as one JSON document
+	JSON = "json"
+	// CSV writes the results as CSV, one row per field
+	CSV = "csv"
+	// SQLITE adds every run to a SQLite database
+	SQLITE = "sqlite"
+	// PARQUET writes the delegations of the zone as Parquet file
+	PARQUET = "parquet"
+)
+
+// PRECISIONS maps the precisions of timestamps to their units.
+var PRECISIONS = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// Point is one measurement with its tags and fields as emitted by a plugin.
+// Field values are integers, floats, booleans or strings. A zero time means
+// the point carries no timestamp.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// NewPoint returns a point without fields, tagged with tld and source.
+func NewPoint(measurement string, tld string, source string) Point {
+	return Point{
+		Measurement: measurement,
+		Tags:        map[string]string{"tld": tld, "source": source},
+		Fields:      make(map[string]interface{}),
+	}
+}
+
+// Kinds of field values
+const (
+	INT    = "int"
+	FLOAT  = "float"
+	BOOL   = "bool"
+	STRING = "string"
+)
+
+// Value returns the kind of a field value and the value as int64, float64,
+// bool or string. Unsigned integers beyond the range of int64 are floats,
+// values of any other type are formatted as strings.
+func Value(value interface{}) (string, interface{}) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return INT, v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return FLOAT, float64(v.Uint())
+		}
+		return INT, int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return FLOAT, v.Float()
+	case reflect.Bool:
+		return BOOL, v.Bool()
+	case reflect.String:
+		return STRING, v.String()
+	}
+	return STRING, fmt.Sprintf("%v", value)
+}
+
+// Number returns the value of a numeric or boolean field as float.
+// Booleans are 1 for true and 0 for false.
+func Number(value interface{}) (float64, bool) {
+	kind, v := Value(value)
+	switch kind {
+	case INT:
+		return float64(v.(int64)), true
+	case FLOAT:
+		return v.(float64), true
+	case BOOL:
+		if v.(bool) {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// FieldKeys returns the names of the fields sorted.
+func FieldKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TagKeys returns the names of the tags sorted.
+func TagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Sink writes the points of a run to its destination.
+type Sink interface {
+	Name() string
+	Write([]Point) error
+}
+
+// Run is the metadata of a run. Serial is the SOA serial of the zone (-1 if
+// unknown), Time the timestamp of the points, Start and End the time the
+// run started and the results were written.
+type Run struct {
+	Zone   string
+	Source string
+	Input  string
+	Serial int64
+	Time   time.Time
+	Start  time.Time
+	End    time.Time
+}
+
+// Recorder is a sink which also records the metadata of the run.
+// SetRun is called before Write.
+type Recorder interface {
+	SetRun(Run)
+}
+
+// Config of a sink as read from the configuration file. Depending on the
+// type, results are written to File or sent to URL or Address. If a sink
+// which is not optional fails, the run fails. Failures of optional sinks
+// are only logged. Delegations adds per-delegation facts where supported.
+type Config struct {
+	Type        string
+	File        string
+	URL         string
+	Address     string
+	Job         string
+	Template    string
+	Resource    map[string]string
+	Headers     map[string]string
+	Delegations bool
+	Optional    bool
+}
@@ -0,0 +1,147 @@
+package parquet
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/derived"
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// VERSION of the schema, written to the metadata of the file. Increase
+// whenever columns are removed or change their meaning.
+const VERSION = 1
+
+// SCHEMA has one row per delegation.
+//
+//	name      domain name of the delegation (FQDN)
+//	ns        name server hosts with their classification as in the nsstats
+//	          measurement Hosts (e.g. InTldGlueIp, ExTldNoIp)
+//	glue      glue addresses of the name server hosts
+//	ds        DS records with keytag, algorithm, digest type and digest
+//	resolved  resolved addresses of the name server hosts, null offline
+var SCHEMA = []Node{
+	String("name"),
+	List("ns", false, String("host"), String("status")),
+	List("glue", false, String("host"), String("address")),
+	List("ds", false, Int32("keytag"), Int32("algorithm"), Int32("digest_type"), String("digest")),
+	List("resolved", true, String("host"), String("address")),
+}
+
+// Parquet exports the delegations of the zone with one row per delegation.
+// The points of the run are not written, the metadata of the run is added
+// to the file.
+type Parquet struct {
+	filename string
+	registry *derived.Registry
+	run      outputs.Run
+}
+
+func New(filename string) *Parquet {
+	return &Parquet{filename: filename}
+}
+
+func (self *Parquet) Name() string {
+	return outputs.PARQUET
+}
+
+func (self *Parquet) SetRun(run outputs.Run) {
+	self.run = run
+}
+
+// Needs returns the shared datasets the delegations are read from.
+func (self *Parquet) Needs() []string {
+	return []string{derived.DomainNS, derived.DomainDS, derived.HostIPs}
+}
+
+// Use hands over the registry the shared datasets are read from.
+func (self *Parquet) Use(registry *derived.Registry) {
+	self.registry = registry
+}
+
+func (self *Parquet) Write(points []outputs.Point) error {
+	file, err := os.Create(self.filename)
+	if err != nil {
+		return err
+	}
+	err = self.write(file)
+	if err != nil {
+		file.Close()
+		os.Remove(self.filename)
+		return err
+	}
+	return file.Close()
+}
+
+func (self *Parquet) write(file *os.File) error {
+	metadata := map[string]string{
+		"zonestats.schema": strconv.Itoa(VERSION),
+		"zonestats.zone":   self.run.Zone,
+		"zonestats.source": self.run.Source,
+		"zonestats.input":  self.run.Input,
+		"zonestats.time":   self.run.Time.UTC().Format(time.RFC3339Nano),
+	}
+	if self.run.Serial >= 0 {
+		metadata["zonestats.serial"] = strconv.FormatInt(self.run.Serial, 10)
+	}
+	writer, err := NewWriter(file, SCHEMA, metadata)
+	if err != nil {
+		return err
+	}
+
+	var writeerr error
+	err = self.registry.WalkDelegations(func(domain string, hosts []string, dslist []derived.DS) {
+		if writeerr != nil {
+			return
+		}
+		ns := make([][]interface{}, 0, len(hosts))
+		glue := make([][]interface{}, 0)
+		var resolved [][]interface{}
+		if !self.registry.Offline() {
+			resolved = make([][]interface{}, 0)
+		}
+		for _, hostname := range hosts {
+			host := self.registry.Hosts().GetHost(hostname)
+			if host == nil {
+				ns = append(ns, []interface{}{hostname, ""})
+				continue
+			}
+			ns = append(ns, []interface{}{hostname, self.registry.HostStatus(host)})
+			host.Access.Lock()
+			for _, ip := range addresses(host.Glue) {
+				glue = append(glue, []interface{}{hostname, ip})
+			}
+			if resolved != nil {
+				for _, ip := range addresses(host.IPs) {
+					resolved = append(resolved, []interface{}{hostname, ip})
+				}
+			}
+			host.Access.Unlock()
+		}
+		ds := make([][]interface{}, 0, len(dslist))
+		for _, record := range dslist {
+			ds = append(ds, []interface{}{int32(record.KeyTag), int32(record.Algorithm), int32(record.DigestType), record.Digest})
+		}
+		writeerr = writer.Write(domain, ns, glue, ds, resolved)
+	})
+	if err != nil {
+		return err
+	}
+	if writeerr != nil {
+		return writeerr
+	}
+	return writer.Close()
+}
+
+// addresses returns the addresses as sorted strings
+func addresses(ips []net.IP) []string {
+	list := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		list = append(list, ip.String())
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,92 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// Types of the Thrift compact protocol used in the metadata
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compact encodes the metadata of Parquet files with the Thrift compact
+// protocol. Only the types needed for the metadata are supported. Fields
+// must be written in the order of their ids.
+type compact struct {
+	buf []byte
+	// last field id of every open struct
+	last []int16
+}
+
+func (self *compact) field(id int16, kind byte) {
+	last := self.last[len(self.last)-1]
+	if id > last && id-last <= 15 {
+		self.buf = append(self.buf, byte(id-last)<<4|kind)
+	} else {
+		self.buf = append(self.buf, kind)
+		self.buf = binary.AppendUvarint(self.buf, zigzag(int64(id)))
+	}
+	self.last[len(self.last)-1] = id
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// begin starts a struct, either the outermost one or an element of a list
+func (self *compact) begin() {
+	self.last = append(self.last, 0)
+}
+
+// end closes the struct started last
+func (self *compact) end() {
+	self.buf = append(self.buf, 0)
+	self.last = self.last[:len(self.last)-1]
+}
+
+// structField starts a struct which is a field of the current struct
+func (self *compact) structField(id int16) {
+	self.field(id, thriftStruct)
+	self.begin()
+}
+
+func (self *compact) i32(id int16, value int32) {
+	self.field(id, thriftI32)
+	self.buf = binary.AppendUvarint(self.buf, zigzag(int64(value)))
+}
+
+func (self *compact) i64(id int16, value int64) {
+	self.field(id, thriftI64)
+	self.buf = binary.AppendUvarint(self.buf, zigzag(value))
+}
+
+func (self *compact) string(id int16, value string) {
+	self.field(id, thriftBinary)
+	self.element(value)
+}
+
+// list starts a list of size elements, which must follow
+func (self *compact) list(id int16, kind byte, size int) {
+	self.field(id, thriftList)
+	if size < 15 {
+		self.buf = append(self.buf, byte(size)<<4|kind)
+	} else {
+		self.buf = append(self.buf, 0xf0|kind)
+		self.buf = binary.AppendUvarint(self.buf, uint64(size))
+	}
+}
+
+// element writes an element of a list of integers or strings
+func (self *compact) element(value interface{}) {
+	switch value := value.(type) {
+	case int32:
+		self.buf = binary.AppendUvarint(self.buf, zigzag(int64(value)))
+	case string:
+		self.buf = binary.AppendUvarint(self.buf, uint64(len(value)))
+		self.buf = append(self.buf, value...)
+	}
+}
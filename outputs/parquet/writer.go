@@ -0,0 +1,379 @@
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Enums of the Parquet metadata
+const (
+	typeInt32     = 1
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+	repetitionRepeated = 2
+
+	convertedUTF8 = 0
+	convertedList = 3
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData          = 0
+	codecUncompressed = 0
+)
+
+// MAGIC starts and ends every Parquet file
+const MAGIC = "PAR1"
+
+// ROWGROUP is the number of rows kept in memory before they are written
+const ROWGROUP = 100000
+
+// Node is a field of the schema. Leaves are strings or 32 bit integers,
+// groups are lists of structs of leaves.
+type Node struct {
+	Name     string
+	Optional bool
+	Type     int32
+	Children []Node
+}
+
+// String returns a required UTF-8 field
+func String(name string) Node {
+	return Node{Name: name, Type: typeByteArray}
+}
+
+// Int32 returns a required 32 bit integer field
+func Int32(name string) Node {
+	return Node{Name: name, Type: typeInt32}
+}
+
+// List returns a list of structs with the fields given, in the three level
+// structure of the Parquet LIST type.
+func List(name string, optional bool, fields ...Node) Node {
+	return Node{Name: name, Optional: optional, Children: fields}
+}
+
+// column buffers the levels and values of one leaf of the schema
+type column struct {
+	path   []string
+	kind   int32
+	maxDef int
+	maxRep int
+	defs   []int
+	reps   []int
+	values []byte
+}
+
+func (self *column) add(def int, rep int, value interface{}) {
+	self.defs = append(self.defs, def)
+	self.reps = append(self.reps, rep)
+	if def < self.maxDef {
+		return
+	}
+	switch value := value.(type) {
+	case string:
+		self.values = binary.LittleEndian.AppendUint32(self.values, uint32(len(value)))
+		self.values = append(self.values, value...)
+	case int32:
+		self.values = binary.LittleEndian.AppendUint32(self.values, uint32(value))
+	}
+}
+
+// page returns a data page with all buffered values
+func (self *column) page() []byte {
+	page := make([]byte, 0, len(self.values)+len(self.defs)/2+16)
+	if self.maxRep > 0 {
+		page = appendLevels(page, self.reps, self.maxRep)
+	}
+	if self.maxDef > 0 {
+		page = appendLevels(page, self.defs, self.maxDef)
+	}
+	return append(page, self.values...)
+}
+
+// appendLevels appends levels in RLE encoding, prefixed by their length
+func appendLevels(buf []byte, levels []int, max int) []byte {
+	width := (bits.Len(uint(max)) + 7) / 8
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		for b := 0; b < width; b++ {
+			buf = append(buf, byte(levels[i]>>(8*b)))
+		}
+		i = j
+	}
+	binary.LittleEndian.PutUint32(buf[start:], uint32(len(buf)-start-4))
+	return buf
+}
+
+type chunk struct {
+	column *column
+	values int
+	offset int64
+	size   int64
+}
+
+type rowGroup struct {
+	chunks []chunk
+	rows   int
+	size   int64
+}
+
+// Writer writes rows to a Parquet file. Values are written PLAIN and
+// uncompressed, one data page per column and row group.
+type Writer struct {
+	out      *bufio.Writer
+	offset   int64
+	schema   []Node
+	columns  []*column
+	first    []int
+	rows     int
+	groups   []rowGroup
+	metadata map[string]string
+}
+
+// NewWriter returns a writer for rows of the schema. Metadata is added as
+// key value pairs to the file.
+func NewWriter(out io.Writer, schema []Node, metadata map[string]string) (*Writer, error) {
+	self := &Writer{out: bufio.NewWriter(out), schema: schema, metadata: metadata}
+	for _, node := range schema {
+		self.first = append(self.first, len(self.columns))
+		if len(node.Children) == 0 {
+			self.columns = append(self.columns, &column{path: []string{node.Name}, kind: node.Type})
+			continue
+		}
+		// the list group adds a definition level if optional, the repeated group one more
+		maxDef := 1
+		if node.Optional {
+			maxDef = 2
+		}
+		for _, leaf := range node.Children {
+			self.columns = append(self.columns, &column{path: []string{node.Name, "list", "element", leaf.Name}, kind: leaf.Type, maxDef: maxDef, maxRep: 1})
+		}
+	}
+	return self, self.write([]byte(MAGIC))
+}
+
+func (self *Writer) write(data []byte) error {
+	n, err := self.out.Write(data)
+	self.offset += int64(n)
+	return err
+}
+
+// Write adds one row. Its values are given in the order of the schema,
+// strings or int32 for leaves, [][]interface{} with the values of every
+// struct for lists. A nil list is null.
+func (self *Writer) Write(row ...interface{}) error {
+	if len(row) != len(self.schema) {
+		return fmt.Errorf("row has %d values, schema %d", len(row), len(self.schema))
+	}
+	for i, node := range self.schema {
+		first := self.first[i]
+		if len(node.Children) == 0 {
+			self.columns[first].add(0, 0, row[i])
+			continue
+		}
+		list, _ := row[i].([][]interface{})
+		maxDef := self.columns[first].maxDef
+		for c := range node.Children {
+			column := self.columns[first+c]
+			if list == nil && node.Optional {
+				column.add(0, 0, nil)
+				continue
+			}
+			if len(list) == 0 {
+				column.add(maxDef-1, 0, nil)
+				continue
+			}
+			for e, element := range list {
+				rep := 1
+				if e == 0 {
+					rep = 0
+				}
+				column.add(maxDef, rep, element[c])
+			}
+		}
+	}
+	self.rows++
+	if self.rows == ROWGROUP {
+		return self.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as row group
+func (self *Writer) flush() error {
+	if self.rows == 0 {
+		return nil
+	}
+	group := rowGroup{rows: self.rows}
+	for _, column := range self.columns {
+		page := column.page()
+		header := compact{}
+		header.begin()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(len(column.defs)))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		c := chunk{column: column, values: len(column.defs), offset: self.offset, size: int64(len(header.buf) + len(page))}
+		err := self.write(header.buf)
+		if err == nil {
+			err = self.write(page)
+		}
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, c)
+		group.size += c.size
+		column.defs = column.defs[:0]
+		column.reps = column.reps[:0]
+		column.values = column.values[:0]
+	}
+	self.groups = append(self.groups, group)
+	self.rows = 0
+	return nil
+}
+
+// Close writes the remaining rows and the footer. It does not close the
+// underlying writer.
+func (self *Writer) Close() error {
+	err := self.flush()
+	if err != nil {
+		return err
+	}
+	footer := compact{}
+	footer.begin()
+	footer.i32(1, 1)
+	self.schemaElements(&footer)
+	rows := 0
+	for _, group := range self.groups {
+		rows += group.rows
+	}
+	footer.i64(3, int64(rows))
+	footer.list(4, thriftStruct, len(self.groups))
+	for _, group := range self.groups {
+		footer.begin()
+		footer.list(1, thriftStruct, len(group.chunks))
+		for _, chunk := range group.chunks {
+			footer.begin()
+			footer.i64(2, chunk.offset)
+			footer.structField(3)
+			footer.i32(1, chunk.column.kind)
+			footer.list(2, thriftI32, 2)
+			footer.element(int32(encodingPlain))
+			footer.element(int32(encodingRLE))
+			footer.list(3, thriftBinary, len(chunk.column.path))
+			for _, name := range chunk.column.path {
+				footer.element(name)
+			}
+			footer.i32(4, codecUncompressed)
+			footer.i64(5, int64(chunk.values))
+			footer.i64(6, chunk.size)
+			footer.i64(7, chunk.size)
+			footer.i64(9, chunk.offset)
+			footer.end()
+			footer.end()
+		}
+		footer.i64(2, group.size)
+		footer.i64(3, int64(group.rows))
+		footer.end()
+	}
+	keys := make([]string, 0, len(self.metadata))
+	for key := range self.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	footer.list(5, thriftStruct, len(keys))
+	for _, key := range keys {
+		footer.begin()
+		footer.string(1, key)
+		footer.string(2, self.metadata[key])
+		footer.end()
+	}
+	footer.string(6, "zonestats")
+	footer.end()
+
+	err = self.write(footer.buf)
+	if err == nil {
+		err = self.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer.buf))))
+	}
+	if err == nil {
+		err = self.write([]byte(MAGIC))
+	}
+	if err != nil {
+		return err
+	}
+	return self.out.Flush()
+}
+
+// schemaElements writes the schema flattened in depth-first order
+func (self *Writer) schemaElements(footer *compact) {
+	count := 1
+	for _, node := range self.schema {
+		count++
+		if len(node.Children) > 0 {
+			count += 2 + len(node.Children)
+		}
+	}
+	footer.list(2, thriftStruct, count)
+	footer.begin()
+	footer.string(4, "schema")
+	footer.i32(5, int32(len(self.schema)))
+	footer.end()
+	for _, node := range self.schema {
+		if len(node.Children) == 0 {
+			leaf(footer, node, repetitionRequired)
+			continue
+		}
+		repetition := int32(repetitionRequired)
+		if node.Optional {
+			repetition = repetitionOptional
+		}
+		group(footer, node.Name, repetition, 1, convertedList)
+		group(footer, "list", repetitionRepeated, 1, -1)
+		group(footer, "element", repetitionRequired, len(node.Children), -1)
+		for _, child := range node.Children {
+			leaf(footer, child, repetitionRequired)
+		}
+	}
+}
+
+func group(footer *compact, name string, repetition int32, children int, converted int32) {
+	footer.begin()
+	footer.i32(3, repetition)
+	footer.string(4, name)
+	footer.i32(5, int32(children))
+	if converted >= 0 {
+		footer.i32(6, converted)
+	}
+	footer.end()
+}
+
+func leaf(footer *compact, node Node, repetition int32) {
+	footer.begin()
+	footer.i32(1, node.Type)
+	footer.i32(3, repetition)
+	footer.string(4, node.Name)
+	if node.Type == typeByteArray {
+		footer.i32(6, convertedUTF8)
+	}
+	footer.end()
+}
@@ -0,0 +1,489 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var testSchema = []Node{
+	String("name"),
+	List("items", true, String("key"), Int32("value")),
+}
+
+// testRows has a null list, an empty list, a list with one and one with
+// several elements
+var testRows = [][]interface{}{
+	{"null.", nil},
+	{"empty.", [][]interface{}{}},
+	{"one.", [][]interface{}{{"a", int32(1)}}},
+	{"many.", [][]interface{}{{"b", int32(-2)}, {"c", int32(3)}, {"", int32(0)}}},
+}
+
+func writeTest(t *testing.T, rows [][]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf, testSchema, map[string]string{"zonestats.test": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := writer.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := writeTest(t, testRows)
+	file := decodeFile(t, data)
+	if file.rows != int64(len(testRows)) {
+		t.Fatalf("footer has %d rows, want %d", file.rows, len(testRows))
+	}
+	wantNames := []string{"schema", "name", "items", "list", "element", "key", "value"}
+	if !reflect.DeepEqual(file.names, wantNames) {
+		t.Fatalf("schema %v, want %v", file.names, wantNames)
+	}
+	if file.metadata["zonestats.test"] != "1" {
+		t.Fatalf("metadata %v", file.metadata)
+	}
+	got := file.assemble(t)
+	if !reflect.DeepEqual(got, testRows) {
+		t.Fatalf("rows\n%#v\nwant\n%#v", got, testRows)
+	}
+}
+
+func TestRowGroups(t *testing.T) {
+	rows := make([][]interface{}, 0, ROWGROUP+3)
+	for i := 0; i < ROWGROUP+3; i++ {
+		rows = append(rows, testRows[i%len(testRows)])
+	}
+	file := decodeFile(t, writeTest(t, rows))
+	if len(file.groups) != 2 {
+		t.Fatalf("%d row groups, want 2", len(file.groups))
+	}
+	if got := file.assemble(t); !reflect.DeepEqual(got, rows) {
+		t.Fatal("rows of several row groups differ")
+	}
+}
+
+func TestGolden(t *testing.T) {
+	data := writeTest(t, testRows)
+	golden := filepath.Join("testdata", "rows.parquet")
+	if *update {
+		if err := os.WriteFile(golden, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("output differs from %s, check it with a Parquet reader and run the test with -update", golden)
+	}
+}
+
+// goldenItems are the lists of testRows in JSON as returned by Parquet readers
+var goldenItems = map[string]string{
+	"null.":  "null",
+	"empty.": "[]",
+	"one.":   `[{"key":"a","value":1}]`,
+	"many.":  `[{"key":"b","value":-2},{"key":"c","value":3},{"key":"","value":0}]`,
+}
+
+// checkItems compares the lists read by a Parquet reader with goldenItems
+func checkItems(t *testing.T, reader string, items map[string]string) {
+	t.Helper()
+	if len(items) != len(goldenItems) {
+		t.Fatalf("%s returned %d rows, want %d", reader, len(items), len(goldenItems))
+	}
+	for name, want := range goldenItems {
+		var a, b interface{}
+		json.Unmarshal([]byte(items[name]), &a)
+		json.Unmarshal([]byte(want), &b)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: %s has items %s, want %s", reader, name, items[name], want)
+		}
+	}
+}
+
+// TestDuckDB reads the golden file with DuckDB, if it is installed
+func TestDuckDB(t *testing.T) {
+	duckdb, err := exec.LookPath("duckdb")
+	if err != nil {
+		t.Skip("duckdb not installed")
+	}
+	golden := filepath.Join("testdata", "rows.parquet")
+	query := fmt.Sprintf("SELECT name, to_json(items) AS items FROM read_parquet('%s') ORDER BY name", golden)
+	out, err := exec.Command(duckdb, "-json", "-c", query).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []struct {
+		Name  string
+		Items interface{}
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	items := make(map[string]string)
+	for _, row := range result {
+		items[row.Name] = "null"
+		if s, ok := row.Items.(string); ok {
+			items[row.Name] = s
+		}
+	}
+	checkItems(t, "duckdb", items)
+}
+
+// TestPyarrow reads the golden file with pyarrow, if it is installed
+func TestPyarrow(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not installed")
+	}
+	if exec.Command(python, "-c", "import pyarrow.parquet").Run() != nil {
+		t.Skip("pyarrow not installed")
+	}
+	script := `import json, sys, pyarrow.parquet as pq
+for row in pq.read_table(sys.argv[1]).to_pylist():
+    print(json.dumps([row["name"], json.dumps(row["items"])]))`
+	out, err := exec.Command(python, "-c", script, filepath.Join("testdata", "rows.parquet")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := make(map[string]string)
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		var row [2]string
+		if err := json.Unmarshal(line, &row); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		items[row[0]] = row[1]
+	}
+	checkItems(t, "pyarrow", items)
+}
+
+// required are the fields parquet.thrift marks as required, readers reject
+// files without them
+var required = map[string][]int16{
+	"FileMetaData":   {1, 2, 3, 4},
+	"SchemaElement":  {4},
+	"RowGroup":       {1, 2, 3},
+	"ColumnChunk":    {2},
+	"ColumnMetaData": {1, 2, 3, 4, 5, 6, 7, 9},
+	"PageHeader":     {1, 2, 3},
+	"DataPageHeader": {1, 2, 3, 4},
+	"KeyValue":       {1},
+}
+
+func checkRequired(t *testing.T, name string, fields map[int16]interface{}) {
+	t.Helper()
+	for _, id := range required[name] {
+		if _, ok := fields[id]; !ok {
+			t.Fatalf("%s: required field %d missing", name, id)
+		}
+	}
+}
+
+// checkSchema walks the schema tree from element i and returns the next
+// element. Groups need the number of children, leaves a type.
+func checkSchema(t *testing.T, elements []interface{}, i int) int {
+	t.Helper()
+	if i >= len(elements) {
+		t.Fatal("schema has less elements than children")
+	}
+	element := elements[i].(map[int16]interface{})
+	checkRequired(t, "SchemaElement", element)
+	children, group := element[5].(int64)
+	if _, leaf := element[1]; leaf == group {
+		t.Fatalf("schema element %s must either have a type or children", element[4])
+	}
+	i++
+	for c := int64(0); c < children; c++ {
+		i = checkSchema(t, elements, i)
+	}
+	return i
+}
+
+// decoded is a Parquet file as read back by the test decoder
+type decoded struct {
+	data     []byte
+	rows     int64
+	names    []string
+	metadata map[string]string
+	groups   [][]decodedChunk
+}
+
+type decodedChunk struct {
+	path   []string
+	values int64
+	offset int64
+	size   int64
+}
+
+func decodeFile(t *testing.T, data []byte) *decoded {
+	t.Helper()
+	if string(data[:4]) != MAGIC || string(data[len(data)-4:]) != MAGIC {
+		t.Fatal("magic missing")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-size : len(data)-8]
+	reader := &thriftReader{buf: footer}
+	meta := reader.readStruct()
+	if reader.err != nil {
+		t.Fatal(reader.err)
+	}
+	if reader.pos != len(footer) {
+		t.Fatalf("footer has %d bytes, %d decoded", len(footer), reader.pos)
+	}
+
+	checkRequired(t, "FileMetaData", meta)
+	if checkSchema(t, meta[2].([]interface{}), 0) != len(meta[2].([]interface{})) {
+		t.Fatal("schema has elements which are not children")
+	}
+	file := &decoded{data: data, rows: meta[3].(int64), metadata: make(map[string]string)}
+	for _, element := range meta[2].([]interface{}) {
+		file.names = append(file.names, string(element.(map[int16]interface{})[4].([]byte)))
+	}
+	for _, kv := range meta[5].([]interface{}) {
+		kv := kv.(map[int16]interface{})
+		checkRequired(t, "KeyValue", kv)
+		file.metadata[string(kv[1].([]byte))] = string(kv[2].([]byte))
+	}
+	for _, group := range meta[4].([]interface{}) {
+		group := group.(map[int16]interface{})
+		checkRequired(t, "RowGroup", group)
+		chunks := make([]decodedChunk, 0)
+		var size int64
+		for _, chunk := range group[1].([]interface{}) {
+			chunk := chunk.(map[int16]interface{})
+			checkRequired(t, "ColumnChunk", chunk)
+			columnMeta := chunk[3].(map[int16]interface{})
+			checkRequired(t, "ColumnMetaData", columnMeta)
+			c := decodedChunk{values: columnMeta[5].(int64), offset: columnMeta[9].(int64), size: columnMeta[7].(int64)}
+			if chunk[2].(int64) != c.offset || columnMeta[6].(int64) != c.size {
+				t.Fatalf("chunk at %d: file offset %d, uncompressed size %d, compressed size %d", c.offset, chunk[2], columnMeta[6], c.size)
+			}
+			for _, name := range columnMeta[3].([]interface{}) {
+				c.path = append(c.path, string(name.([]byte)))
+			}
+			chunks = append(chunks, c)
+			size += c.size
+		}
+		if group[2].(int64) != size {
+			t.Fatalf("row group has %d bytes, chunks %d", group[2], size)
+		}
+		file.groups = append(file.groups, chunks)
+	}
+	return file
+}
+
+// levels and values of one column chunk
+type columnData struct {
+	defs   []int
+	reps   []int
+	values []interface{}
+}
+
+func (self *decoded) column(t *testing.T, chunk decodedChunk, maxDef, maxRep int, kind int32) columnData {
+	t.Helper()
+	reader := &thriftReader{buf: self.data[chunk.offset:]}
+	header := reader.readStruct()
+	if reader.err != nil {
+		t.Fatal(reader.err)
+	}
+	checkRequired(t, "PageHeader", header)
+	if header[1].(int64) != pageData {
+		t.Fatalf("%v: page type %d", chunk.path, header[1])
+	}
+	if header[2].(int64) != header[3].(int64) || int64(reader.pos)+header[3].(int64) != chunk.size {
+		t.Fatalf("%v: page of %d bytes, chunk %d", chunk.path, int64(reader.pos)+header[3].(int64), chunk.size)
+	}
+	dataHeader := header[5].(map[int16]interface{})
+	checkRequired(t, "DataPageHeader", dataHeader)
+	count := int(dataHeader[1].(int64))
+	if int64(count) != chunk.values {
+		t.Fatalf("%v: page has %d values, chunk %d", chunk.path, count, chunk.values)
+	}
+	page := self.data[int(chunk.offset)+reader.pos:]
+	page = page[:header[3].(int64)]
+
+	var result columnData
+	if maxRep > 0 {
+		result.reps, page = decodeLevels(t, page, count)
+	} else {
+		result.reps = make([]int, count)
+	}
+	if maxDef > 0 {
+		result.defs, page = decodeLevels(t, page, count)
+	} else {
+		result.defs = make([]int, count)
+	}
+	for _, def := range result.defs {
+		if def < maxDef {
+			result.values = append(result.values, nil)
+			continue
+		}
+		switch kind {
+		case typeInt32:
+			result.values = append(result.values, int32(binary.LittleEndian.Uint32(page)))
+			page = page[4:]
+		case typeByteArray:
+			n := binary.LittleEndian.Uint32(page)
+			result.values = append(result.values, string(page[4:4+n]))
+			page = page[4+n:]
+		}
+	}
+	if len(page) != 0 {
+		t.Fatalf("%v: %d bytes left in page", chunk.path, len(page))
+	}
+	return result
+}
+
+// decodeLevels decodes length prefixed levels of the RLE/bit-packed hybrid
+// encoding, only the RLE runs written by the writer are supported
+func decodeLevels(t *testing.T, page []byte, count int) ([]int, []byte) {
+	t.Helper()
+	length := binary.LittleEndian.Uint32(page)
+	data := page[4 : 4+length]
+	levels := make([]int, 0, count)
+	for len(data) > 0 {
+		header, n := binary.Uvarint(data)
+		data = data[n:]
+		if header&1 != 0 {
+			t.Fatal("bit-packed run")
+		}
+		levels = append(levels, make([]int, header>>1)...)
+		for i := len(levels) - int(header>>1); i < len(levels); i++ {
+			levels[i] = int(data[0])
+		}
+		data = data[1:]
+	}
+	if len(levels) != count {
+		t.Fatalf("%d levels, want %d", len(levels), count)
+	}
+	return levels, page[4+length:]
+}
+
+// assemble reads all row groups back into rows of testSchema
+func (self *decoded) assemble(t *testing.T) [][]interface{} {
+	t.Helper()
+	rows := make([][]interface{}, 0)
+	for _, group := range self.groups {
+		if len(group) != 3 {
+			t.Fatalf("%d column chunks", len(group))
+		}
+		names := self.column(t, group[0], 0, 0, typeByteArray)
+		keys := self.column(t, group[1], 2, 1, typeByteArray)
+		values := self.column(t, group[2], 2, 1, typeInt32)
+		if !reflect.DeepEqual(keys.defs, values.defs) || !reflect.DeepEqual(keys.reps, values.reps) {
+			t.Fatal("levels of the list columns differ")
+		}
+		i := 0
+		for _, name := range names.values {
+			row := []interface{}{name, nil}
+			switch keys.defs[i] {
+			case 0:
+				i++
+			case 1:
+				row[1] = [][]interface{}{}
+				i++
+			default:
+				items := [][]interface{}{{keys.values[i], values.values[i]}}
+				for i++; i < len(keys.defs) && keys.reps[i] == 1; i++ {
+					items = append(items, []interface{}{keys.values[i], values.values[i]})
+				}
+				row[1] = items
+			}
+			rows = append(rows, row)
+		}
+		if i != len(keys.defs) {
+			t.Fatalf("%d of %d list values read", i, len(keys.defs))
+		}
+	}
+	return rows
+}
+
+// thriftReader decodes the Thrift compact protocol. Structs are returned
+// as maps from field id to value, integers as int64, binary as []byte.
+type thriftReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (self *thriftReader) uvarint() uint64 {
+	value, n := binary.Uvarint(self.buf[self.pos:])
+	if n <= 0 {
+		self.err = fmt.Errorf("bad varint at %d", self.pos)
+		return 0
+	}
+	self.pos += n
+	return value
+}
+
+func (self *thriftReader) varint() int64 {
+	value := self.uvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (self *thriftReader) readStruct() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for self.err == nil {
+		b := self.buf[self.pos]
+		self.pos++
+		if b == 0 {
+			break
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(self.varint())
+		}
+		last = id
+		fields[id] = self.read(b & 0x0f)
+	}
+	return fields
+}
+
+func (self *thriftReader) read(kind byte) interface{} {
+	switch kind {
+	case thriftI32, thriftI64:
+		return self.varint()
+	case thriftBinary:
+		n := int(self.uvarint())
+		value := self.buf[self.pos : self.pos+n]
+		self.pos += n
+		return value
+	case thriftStruct:
+		return self.readStruct()
+	case thriftList:
+		b := self.buf[self.pos]
+		self.pos++
+		size := int(b >> 4)
+		if size == 15 {
+			size = int(self.uvarint())
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			list = append(list, self.read(b&0x0f))
+		}
+		return list
+	}
+	self.err = fmt.Errorf("unsupported type %d at %d", kind, self.pos)
+	return nil
+}
@@ -0,0 +1,221 @@
+package prometheus
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// JOB is the default job name on the Pushgateway
+const JOB = "zonestats"
+
+// TIMEOUT of a push to the Pushgateway
+const TIMEOUT = 30 * time.Second
+
+// Prometheus renders points in the Prometheus text exposition format. The
+// result is either written to a file for the textfile collector of the
+// node exporter or pushed to a Pushgateway.
+type Prometheus struct {
+	filename string
+	gateway  string
+	job      string
+	client   *http.Client
+}
+
+// NewTextfile returns a sink writing to a file of the textfile collector.
+func NewTextfile(filename string) *Prometheus {
+	return &Prometheus{filename: filename}
+}
+
+// NewPushgateway returns a sink pushing to a Pushgateway. The metrics are
+// grouped by job and tld, every push replaces the metrics of the group.
+func NewPushgateway(gateway string, job string) *Prometheus {
+	if len(job) == 0 {
+		job = JOB
+	}
+	return &Prometheus{gateway: gateway, job: job, client: &http.Client{Timeout: TIMEOUT}}
+}
+
+func (self *Prometheus) Name() string {
+	if len(self.gateway) > 0 {
+		return outputs.PUSHGATEWAY
+	}
+	return outputs.PROMETHEUS
+}
+
+func (self *Prometheus) Write(points []outputs.Point) error {
+	if len(self.gateway) > 0 {
+		return self.push(points)
+	}
+
+	// the collector must never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(self.filename), "."+filepath.Base(self.filename))
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(Exposition(points))
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), self.filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (self *Prometheus) push(points []outputs.Point) error {
+	tld := ""
+	if len(points) > 0 {
+		tld = points[0].Tags["tld"]
+	}
+	pushurl := strings.TrimSuffix(self.gateway, "/") + "/metrics/" + grouping("job", self.job) + "/" + grouping("tld", tld)
+	req, err := http.NewRequest(http.MethodPut, pushurl, bytes.NewReader(Exposition(points)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Pushgateway answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// grouping returns one label of the grouping key as path segments. Empty
+// values and values with a slash are base64url encoded, as the Pushgateway
+// can not parse them otherwise.
+func grouping(name string, value string) string {
+	if len(value) == 0 {
+		return name + "@base64/="
+	}
+	if strings.Contains(value, "/") {
+		return name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return name + "/" + url.PathEscape(value)
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+// Exposition renders the points as gauges. Every numeric or boolean field
+// becomes a metric zonestats_<measurement>_<field> in snake case, the field
+// value gives just zonestats_<measurement>. Tags become labels. String
+// fields and timestamps are left out, as the textfile collector and the
+// Pushgateway do not accept timestamps.
+func Exposition(points []outputs.Point) []byte {
+	metrics := make(map[string][]sample)
+	help := make(map[string]string)
+	for _, point := range points {
+		labels := Labels(point.Tags)
+		for field, value := range point.Fields {
+			number, ok := outputs.Number(value)
+			if !ok {
+				continue
+			}
+			name := MetricName(point.Measurement, field)
+			metrics[name] = append(metrics[name], sample{labels: labels, value: number})
+			help[name] = fmt.Sprintf("zonestats measurement %s field %s", point.Measurement, field)
+		}
+	}
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		samples := metrics[name]
+		sort.Slice(samples, func(i, j int) bool { return samples[i].labels < samples[j].labels })
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp.Replace(help[name]))
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, sample := range samples {
+			fmt.Fprintf(&buf, "%s%s %g\n", name, sample.labels, sample.value)
+		}
+	}
+	return buf.Bytes()
+}
+
+var escapeHelp = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+var escapeLabel = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// Labels returns the tags as label set sorted by name
+func Labels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(tags))
+	for _, key := range outputs.TagKeys(tags) {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", sanitize(key, false), escapeLabel.Replace(tags[key])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// MetricName returns the name of the metric for a field of a measurement.
+func MetricName(measurement string, field string) string {
+	name := snakeCase(measurement)
+	if !strings.HasPrefix(name, "zonestats_") {
+		name = "zonestats_" + name
+	}
+	if field != "value" {
+		name = name + "_" + snakeCase(field)
+	}
+	return sanitize(name, true)
+}
+
+// snakeCase turns CamelCase into snake_case, CountRR becomes count_rr and
+// InTldGlueIp becomes in_tld_glue_ip.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	snake := make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			next := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || (unicode.IsUpper(previous) && next) {
+				snake = append(snake, '_')
+			}
+		}
+		snake = append(snake, unicode.ToLower(r))
+	}
+	return string(snake)
+}
+
+// sanitize replaces all characters not allowed in metric or label names
+func sanitize(name string, metric bool) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		valid := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') || (metric && r == ':')
+		if !valid {
+			runes[i] = '_'
+		}
+	}
+	return string(runes)
+}
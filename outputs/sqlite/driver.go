@@ -0,0 +1,10 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// errDriver is nil, the driver is compiled in
+var errDriver error
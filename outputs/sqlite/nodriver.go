@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package sqlite
+
+import (
+	"errors"
+)
+
+// errDriver is returned by Write, github.com/mattn/go-sqlite3 needs cgo
+var errDriver = errors.New("sqlite: zonestats was built without cgo (CGO_ENABLED=0), rebuild with cgo to use the SQLite sink")
@@ -0,0 +1,267 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/derived"
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// SCHEMA creates the tables if the database is new. Times are stored as
+// RFC 3339 in UTC, tags as JSON object (use json_extract to select them).
+// The delegation and host tables are only filled if delegations are
+// written.
+var SCHEMA = []string{
+	`CREATE TABLE IF NOT EXISTS runs (
+		id INTEGER PRIMARY KEY,
+		zone TEXT NOT NULL,
+		source TEXT NOT NULL,
+		input TEXT NOT NULL,
+		serial INTEGER,
+		time TEXT NOT NULL,
+		started TEXT NOT NULL,
+		finished TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS measurements (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		measurement TEXT NOT NULL,
+		tags TEXT NOT NULL,
+		field TEXT NOT NULL,
+		type TEXT NOT NULL,
+		value
+	)`,
+	`CREATE INDEX IF NOT EXISTS measurements_run ON measurements (run_id, measurement)`,
+	`CREATE TABLE IF NOT EXISTS delegation_ns (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		domain TEXT NOT NULL,
+		host TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS delegation_ns_run ON delegation_ns (run_id, domain)`,
+	`CREATE TABLE IF NOT EXISTS delegation_ds (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		domain TEXT NOT NULL,
+		keytag INTEGER NOT NULL,
+		algorithm INTEGER NOT NULL,
+		digest_type INTEGER NOT NULL,
+		digest TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS delegation_ds_run ON delegation_ds (run_id, domain)`,
+	`CREATE TABLE IF NOT EXISTS hosts (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		host TEXT NOT NULL,
+		in_zone INTEGER NOT NULL,
+		glue INTEGER NOT NULL,
+		resolved INTEGER,
+		status TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS hosts_run ON hosts (run_id, host)`,
+	`CREATE TABLE IF NOT EXISTS host_addresses (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		host TEXT NOT NULL,
+		address TEXT NOT NULL,
+		kind TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS host_addresses_run ON host_addresses (run_id, host)`,
+}
+
+// SQLite writes every run into a local database. Each run adds one row to
+// the table runs and one row per field to measurements. With delegations,
+// the name servers and DS records of every delegation and the glue and
+// resolved addresses of every name server host are added as well.
+type SQLite struct {
+	filename    string
+	delegations bool
+	registry    *derived.Registry
+	run         outputs.Run
+}
+
+func New(filename string, delegations bool) *SQLite {
+	return &SQLite{filename: filename, delegations: delegations}
+}
+
+func (self *SQLite) Name() string {
+	return outputs.SQLITE
+}
+
+func (self *SQLite) SetRun(run outputs.Run) {
+	self.run = run
+}
+
+// Needs returns the shared datasets the delegation tables are filled from.
+func (self *SQLite) Needs() []string {
+	if !self.delegations {
+		return []string{}
+	}
+	return []string{derived.DomainNS, derived.DomainDS, derived.HostIPs}
+}
+
+// Use hands over the registry the shared datasets are read from.
+func (self *SQLite) Use(registry *derived.Registry) {
+	self.registry = registry
+}
+
+// Write adds the run in one transaction, a failed run leaves no rows behind.
+func (self *SQLite) Write(points []outputs.Point) error {
+	if errDriver != nil {
+		return errDriver
+	}
+	db, err := sql.Open("sqlite3", self.filename)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, statement := range SCHEMA {
+		_, err = db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	err = self.write(tx, points)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (self *SQLite) write(tx *sql.Tx, points []outputs.Point) error {
+	var serial interface{}
+	if self.run.Serial >= 0 {
+		serial = self.run.Serial
+	}
+	result, err := tx.Exec(`INSERT INTO runs (zone, source, input, serial, time, started, finished) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		self.run.Zone, self.run.Source, self.run.Input, serial, timeString(self.run.Time), timeString(self.run.Start), timeString(self.run.End))
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO measurements (run_id, measurement, tags, field, type, value) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, point := range points {
+		tags, err := json.Marshal(point.Tags)
+		if err != nil {
+			return err
+		}
+		for _, field := range outputs.FieldKeys(point.Fields) {
+			kind, value := outputs.Value(point.Fields[field])
+			if kind == outputs.BOOL {
+				value = boolean(value.(bool))
+			}
+			_, err = insert.Exec(id, point.Measurement, string(tags), field, kind, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if self.delegations {
+		return self.writeDelegations(tx, id)
+	}
+	return nil
+}
+
+// writeDelegations adds the delegations and name server hosts of the run
+func (self *SQLite) writeDelegations(tx *sql.Tx, id int64) error {
+	insertNS, err := tx.Prepare(`INSERT INTO delegation_ns (run_id, domain, host) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertNS.Close()
+	insertDS, err := tx.Prepare(`INSERT INTO delegation_ds (run_id, domain, keytag, algorithm, digest_type, digest) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertDS.Close()
+	var inserterr error
+	err = self.registry.WalkDelegations(func(domain string, hosts []string, dslist []derived.DS) {
+		for _, host := range hosts {
+			if inserterr == nil {
+				_, inserterr = insertNS.Exec(id, domain, host)
+			}
+		}
+		for _, ds := range dslist {
+			if inserterr == nil {
+				_, inserterr = insertDS.Exec(id, domain, ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if inserterr != nil {
+		return inserterr
+	}
+
+	insertHost, err := tx.Prepare(`INSERT INTO hosts (run_id, host, in_zone, glue, resolved, status) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertHost.Close()
+	insertAddress, err := tx.Prepare(`INSERT INTO host_addresses (run_id, host, address, kind) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertAddress.Close()
+	hostnames := self.registry.Hosts().GetAllHostnames()
+	sort.Strings(hostnames)
+	for _, hostname := range hostnames {
+		host := self.registry.Hosts().GetHost(hostname)
+		status := self.registry.HostStatus(host)
+		host.Access.Lock()
+		glue := append([]net.IP(nil), host.Glue...)
+		ips := append([]net.IP(nil), host.IPs...)
+		inzone := host.IsTldHost
+		host.Access.Unlock()
+
+		// the number of resolved addresses is unknown in offline mode
+		var resolved interface{}
+		if !self.registry.Offline() {
+			resolved = len(ips)
+		}
+		_, err = insertHost.Exec(id, hostname, inzone, len(glue), resolved, status)
+		if err != nil {
+			return err
+		}
+		for _, ip := range glue {
+			_, err = insertAddress.Exec(id, hostname, ip.String(), "glue")
+			if err != nil {
+				return err
+			}
+		}
+		for _, ip := range ips {
+			_, err = insertAddress.Exec(id, hostname, ip.String(), "resolved")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// boolean returns the value booleans are stored as
+func boolean(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func timeString(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
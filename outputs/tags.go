@@ -0,0 +1,139 @@
+package outputs
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// OTHER replaces the values of a tag above its limit
+const OTHER = "other"
+
+// TagLimit limits the number of distinct values of a tag of a measurement.
+type TagLimit struct {
+	Measurement string
+	Tag         string
+	Limit       uint
+}
+
+// AddTags adds static tags to all points. Tags a point already has are
+// not changed.
+func AddTags(points []Point, tags map[string]string) {
+	for _, point := range points {
+		for key, value := range tags {
+			if _, ok := point.Tags[key]; !ok {
+				point.Tags[key] = value
+			}
+		}
+	}
+}
+
+// Limit keeps the values of the tag with the largest totals (the sum of all
+// integer fields) and replaces all other values by OTHER. Points which then
+// have equal tags are joined into one, integer fields are summed, all other
+// fields are taken from the first point. If OTHER is a kept value itself,
+// the replaced points are joined into the point which has it.
+func Limit(points []Point, limit TagLimit) []Point {
+	totals := make(map[string]int64)
+	for _, point := range points {
+		if value, ok := point.Tags[limit.Tag]; ok && point.Measurement == limit.Measurement {
+			totals[value] += total(point)
+		}
+	}
+	if uint(len(totals)) <= limit.Limit {
+		return points
+	}
+	values := make([]string, 0, len(totals))
+	for value := range totals {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if totals[values[i]] != totals[values[j]] {
+			return totals[values[i]] > totals[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	keep := make(map[string]bool)
+	for _, value := range values[:limit.Limit] {
+		keep[value] = true
+	}
+
+	// others indexes the points tagged OTHER in limited, they are copies,
+	// so joining does not change the points given
+	limited := make([]Point, 0, len(points))
+	others := make(map[string]int)
+	for _, point := range points {
+		value, ok := point.Tags[limit.Tag]
+		if !ok || point.Measurement != limit.Measurement || (keep[value] && value != OTHER) {
+			limited = append(limited, point)
+			continue
+		}
+		other := clone(point)
+		other.Tags[limit.Tag] = OTHER
+		key := tagKey(other.Tags)
+		if i, ok := others[key]; ok {
+			join(limited[i], point)
+			continue
+		}
+		others[key] = len(limited)
+		limited = append(limited, other)
+	}
+	return limited
+}
+
+// clone returns a copy of point with its own tags and fields
+func clone(point Point) Point {
+	copied := Point{Measurement: point.Measurement, Tags: make(map[string]string), Fields: make(map[string]interface{}), Time: point.Time}
+	for key, value := range point.Tags {
+		copied.Tags[key] = value
+	}
+	for field, value := range point.Fields {
+		copied.Fields[field] = value
+	}
+	return copied
+}
+
+// join adds the fields of point to joined
+func join(joined Point, point Point) {
+	for field, value := range point.Fields {
+		current, ok := joined.Fields[field]
+		if !ok {
+			joined.Fields[field] = value
+			continue
+		}
+		a, aok := integer(current)
+		b, bok := integer(value)
+		if aok && bok {
+			joined.Fields[field] = a + b
+		}
+	}
+}
+
+// total returns the sum of all integer fields of a point
+func total(point Point) int64 {
+	var sum int64
+	for _, value := range point.Fields {
+		if number, ok := integer(value); ok {
+			sum += number
+		}
+	}
+	return sum
+}
+
+func integer(value interface{}) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// tagKey returns the tags sorted by name as one string. Names and values
+// are JSON encoded, so tags with separators in them can not collide.
+func tagKey(tags map[string]string) string {
+	key, _ := json.Marshal(tags)
+	return string(key)
+}
@@ -1,38 +1,99 @@
 package countdom
 
 import (
-	"fmt"
+	"encoding/json"
 	"sync"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/hll"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/spill"
 )
 
 type CountDom struct {
-	count  map[string]uint
+	names  *spill.Set
+	sketch *hll.Sketch
+	count  uint
 	access sync.Mutex
 }
 
-func Init() *CountDom {
+// Init creates the plugin. With a precision > 0 names are not counted
+// exactly but estimated by a HyperLogLog sketch of that precision.
+func Init(precision uint) *CountDom {
 	self := CountDom{}
-	self.count = make(map[string]uint)
+	if precision > 0 {
+		self.sketch = hll.New(precision)
+	} else {
+		self.names = spill.New()
+	}
 	self.access = sync.Mutex{}
 	return &self
 }
 
 func (self *CountDom) Receive(rr dns.RR, wg *sync.WaitGroup) {
 	defer wg.Done()
-	dom := rr.Header().Name
+	if self.sketch != nil {
+		self.sketch.Add(rr.Header().Name)
+		return
+	}
+	err := self.names.Add(rr.Header().Name)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (self *CountDom) Done() {
+	if self.sketch != nil {
+		self.access.Lock()
+		defer self.access.Unlock()
+		self.count = self.sketch.Count()
+		return
+	}
+	count, err := self.names.Len()
+	if err != nil {
+		panic(err)
+	}
+	self.names.Close()
 	self.access.Lock()
 	defer self.access.Unlock()
-	if _, ok := self.count[dom]; !ok {
-		self.count[dom] = 0
+	self.count = count
+}
+
+func (self *CountDom) Name() string {
+	return "CountDom"
+}
+
+// SavePartial saves the sketch of approximate runs, the names of exact runs
+// are saved as set.
+func (self *CountDom) SavePartial() ([]byte, error) {
+	return json.Marshal(self.sketch)
+}
+
+func (self *CountDom) MergePartial(data []byte) error {
+	if self.sketch == nil {
+		return nil
 	}
-	self.count[dom]++
+	sketch := &hll.Sketch{}
+	err := json.Unmarshal(data, sketch)
+	if err != nil {
+		return err
+	}
+	return self.sketch.Merge(sketch)
 }
 
-func (self *CountDom) Done() {
+// Sets returns the names of exact runs.
+func (self *CountDom) Sets() map[string]*spill.Set {
+	if self.names == nil {
+		return nil
+	}
+	return map[string]*spill.Set{"names": self.names}
 }
 
-func (self *CountDom) Influx(tld string, source string) string {
-	return fmt.Sprintf("CountDom,tld=%s,source=%s value=%di\n", tld, source, len(self.count))
+func (self *CountDom) Points(tld string, source string) []outputs.Point {
+	point := outputs.NewPoint("CountDom", tld, source)
+	point.Fields["value"] = int64(self.count)
+	if self.sketch != nil {
+		point.Fields["error"] = self.sketch.Error()
+	}
+	return []outputs.Point{point}
 }
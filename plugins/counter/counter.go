@@ -0,0 +1,265 @@
+package counter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/spill"
+)
+
+// Fields which are not part of the rdata
+const (
+	OWNER = "owner"
+	TYPE  = "type"
+	CLASS = "class"
+	TTL   = "ttl"
+)
+
+// stringlist can be given as a single string or as a list in YAML
+type stringlist []string
+
+func (self *stringlist) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*self = list
+		return nil
+	}
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	*self = []string{str}
+	return nil
+}
+
+// Filter selects the records to count. All given conditions must match.
+// Owner and rdata fields are matched case insensitive against glob patterns.
+type Filter struct {
+	Type   stringlist
+	Owner  string
+	TTLMin *uint32
+	TTLMax *uint32
+	Rdata  map[string]string
+}
+
+// Config of a counter as read from the configuration file.
+type Config struct {
+	Measurement string
+	Filter      Filter
+	GroupBy     stringlist
+}
+
+// Counter counts the records matching a filter, grouped by the values of
+// some fields. Each group is written with the group values as tags.
+type Counter struct {
+	access sync.Mutex
+	config Config
+	types  map[uint16]bool
+	counts map[string]uint
+}
+
+func Init(config Config) (*Counter, error) {
+	self := Counter{}
+	self.config = config
+	self.types = make(map[uint16]bool)
+	self.counts = make(map[string]uint)
+	if len(config.Measurement) == 0 {
+		return nil, errors.New("counter without measurement name")
+	}
+	for _, name := range config.Filter.Type {
+		rrtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("counter %s: unknown type %s", config.Measurement, name)
+		}
+		self.types[rrtype] = true
+	}
+	if _, err := path.Match(config.Filter.Owner, ""); err != nil {
+		return nil, fmt.Errorf("counter %s: owner %s: %s", config.Measurement, config.Filter.Owner, err)
+	}
+	for field, pattern := range config.Filter.Rdata {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("counter %s: rdata %s: %s", config.Measurement, field, err)
+		}
+		if err := self.checkField(field); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range config.GroupBy {
+		if field == "tld" || field == "source" {
+			return nil, fmt.Errorf("counter %s: can not group by reserved tag %s", config.Measurement, field)
+		}
+		switch field {
+		case OWNER, TYPE, CLASS, TTL:
+			continue
+		}
+		if err := self.checkField(field); err != nil {
+			return nil, err
+		}
+	}
+	return &self, nil
+}
+
+// checkField makes sure all filtered types have a rdata field
+func (self *Counter) checkField(field string) error {
+	if len(self.types) == 0 {
+		return fmt.Errorf("counter %s: rdata field %s needs a type filter", self.config.Measurement, field)
+	}
+	for rrtype := range self.types {
+		newrr, ok := dns.TypeToRR[rrtype]
+		if !ok {
+			return fmt.Errorf("counter %s: type %s has no rdata fields", self.config.Measurement, dns.Type(rrtype).String())
+		}
+		if _, ok := rdataField(newrr(), field); !ok {
+			return fmt.Errorf("counter %s: type %s has no rdata field %s", self.config.Measurement, dns.Type(rrtype).String(), field)
+		}
+	}
+	return nil
+}
+
+// rdataField returns the value of a field of the rdata by its (case insensitive) name
+func rdataField(rr dns.RR, name string) (string, bool) {
+	value := reflect.ValueOf(rr)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return "", false
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Name == "Hdr" || !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		switch v := value.Field(i).Interface().(type) {
+		case net.IP:
+			return v.String(), true
+		case []string:
+			return strings.Join(v, " "), true
+		default:
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
+
+// field returns the value of a header or rdata field
+func field(rr dns.RR, name string) string {
+	header := rr.Header()
+	switch name {
+	case OWNER:
+		return header.Name
+	case TYPE:
+		return dns.Type(header.Rrtype).String()
+	case CLASS:
+		return dns.Class(header.Class).String()
+	case TTL:
+		return fmt.Sprint(header.Ttl)
+	}
+	value, _ := rdataField(rr, name)
+	return value
+}
+
+func match(pattern string, value string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return ok
+}
+
+func (self *Counter) matches(rr dns.RR) bool {
+	header := rr.Header()
+	filter := self.config.Filter
+	if len(self.types) > 0 && !self.types[header.Rrtype] {
+		return false
+	}
+	if len(filter.Owner) > 0 && !match(filter.Owner, header.Name) {
+		return false
+	}
+	if filter.TTLMin != nil && header.Ttl < *filter.TTLMin {
+		return false
+	}
+	if filter.TTLMax != nil && header.Ttl > *filter.TTLMax {
+		return false
+	}
+	for name, pattern := range filter.Rdata {
+		value, ok := rdataField(rr, name)
+		if !ok || !match(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *Counter) Receive(rr dns.RR, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if !self.matches(rr) {
+		return
+	}
+	values := make([]string, len(self.config.GroupBy))
+	for i, name := range self.config.GroupBy {
+		values[i] = field(rr, name)
+	}
+	key := spill.Join(values...)
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.counts[key]++
+}
+
+func (self *Counter) Done() {
+}
+
+func (self *Counter) Name() string {
+	return self.config.Measurement
+}
+
+func (self *Counter) SavePartial() ([]byte, error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return json.Marshal(self.counts)
+}
+
+func (self *Counter) MergePartial(data []byte) error {
+	counts := make(map[string]uint)
+	err := json.Unmarshal(data, &counts)
+	if err != nil {
+		return err
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	for key, n := range counts {
+		self.counts[key] += n
+	}
+	return nil
+}
+
+func (self *Counter) Points(tld string, source string) []outputs.Point {
+	self.access.Lock()
+	defer self.access.Unlock()
+	keys := make([]string, 0, len(self.counts))
+	for key := range self.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	points := make([]outputs.Point, 0, len(keys))
+	for _, key := range keys {
+		point := outputs.NewPoint(self.config.Measurement, tld, source)
+		if len(self.config.GroupBy) > 0 {
+			for i, value := range spill.Split(key) {
+				if len(value) == 0 {
+					value = "none"
+				}
+				point.Tags[self.config.GroupBy[i]] = value
+			}
+		}
+		point.Fields["count"] = int64(self.counts[key])
+		points = append(points, point)
+	}
+	return points
+}
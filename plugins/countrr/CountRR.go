@@ -1,11 +1,12 @@
 package countrr
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
 	"sync"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/outputs"
 )
 
 type CountRR struct {
@@ -37,13 +38,32 @@ func (self *CountRR) Receive(rr dns.RR, wg *sync.WaitGroup) {
 func (self *CountRR) Done() {
 }
 
-func (self *CountRR) Influx(tld string, source string) string {
-	line := fmt.Sprintf("CountRR,tld=%s,source=%s ", tld, source)
-	seperator := ""
+func (self *CountRR) Name() string {
+	return "CountRR"
+}
+
+func (self *CountRR) SavePartial() ([]byte, error) {
+	return json.Marshal(self.count)
+}
+
+func (self *CountRR) MergePartial(data []byte) error {
+	count := make(map[string]uint)
+	err := json.Unmarshal(data, &count)
+	if err != nil {
+		return err
+	}
+	self.access.Lock()
+	defer self.access.Unlock()
+	for rrtype, n := range count {
+		self.count[rrtype] += n
+	}
+	return nil
+}
+
+func (self *CountRR) Points(tld string, source string) []outputs.Point {
+	point := outputs.NewPoint("CountRR", tld, source)
 	for rrtype, count := range self.count {
-		line = line + seperator + fmt.Sprintf("%s=%di", rrtype, count)
-		seperator = ","
+		point.Fields[rrtype] = int64(count)
 	}
-	line = line + "\n"
-	return line
+	return []outputs.Point{point}
 }
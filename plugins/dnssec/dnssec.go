@@ -1,49 +1,79 @@
 package dnssec
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/hll"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/spill"
 )
 
+// DNSSEC counts signed domains and their DS algorithms and digest types.
+// In approximate mode the domains are counted by HyperLogLog sketches,
+// CountDomDS and CountDomDnskey are then not computed.
 type DNSSEC struct {
-	measurement    map[string]map[uint8]map[uint8]uint
+	measurement    *spill.Set
+	precision      uint
+	domains        *hll.Sketch
+	ds             map[uint8]map[uint8]*hll.Sketch
 	CountDS        map[uint8]map[uint8]uint
 	CountDomDS     map[uint8]uint
 	CountDomDnskey map[uint8]uint
+	CountDomSigned uint
 	access         sync.Mutex
 }
 
-func Init() *DNSSEC {
+// Init creates the plugin. With a precision > 0 domains are not counted
+// exactly but estimated by HyperLogLog sketches of that precision.
+func Init(precision uint) *DNSSEC {
 	self := DNSSEC{}
-	self.measurement = make(map[string]map[uint8]map[uint8]uint)
+	self.precision = precision
+	if precision > 0 {
+		self.domains = hll.New(precision)
+		self.ds = make(map[uint8]map[uint8]*hll.Sketch)
+	} else {
+		self.measurement = spill.New()
+	}
 	self.access = sync.Mutex{}
 	return &self
 }
 
+// dsSketch returns the sketch counting domains for algorithm and digest type
+func (self *DNSSEC) dsSketch(alg uint8, digest uint8) *hll.Sketch {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if _, ok := self.ds[alg]; !ok {
+		self.ds[alg] = make(map[uint8]*hll.Sketch)
+	}
+	if _, ok := self.ds[alg][digest]; !ok {
+		self.ds[alg][digest] = hll.New(self.precision)
+	}
+	return self.ds[alg][digest]
+}
+
 func (self *DNSSEC) Receive(rr dns.RR, wg *sync.WaitGroup) {
 	defer wg.Done()
-	self.access.Lock()
 	switch rr.(type) {
 	case *dns.DS:
 		ds := rr.(*dns.DS)
 		dom := rr.Header().Name
 
-		// count algorithm
-		if _, ok := self.measurement[dom]; !ok {
-			self.measurement[dom] = make(map[uint8]map[uint8]uint)
+		if self.precision > 0 {
+			self.domains.Add(dom)
+			self.dsSketch(ds.Algorithm, ds.DigestType).Add(dom)
+			return
 		}
-		if _, ok := self.measurement[dom][ds.Algorithm]; !ok {
-			self.measurement[dom][ds.Algorithm] = make(map[uint8]uint, 0)
-		}
-		if _, ok := self.measurement[dom][ds.Algorithm][ds.DigestType]; !ok {
-			self.measurement[dom][ds.Algorithm][ds.DigestType] = 1
-		} else {
-			self.measurement[dom][ds.Algorithm][ds.DigestType]++
+
+		// remember algorithm and digest type per domain
+		err := self.measurement.Add(spill.Join(dom, strconv.Itoa(int(ds.Algorithm)), strconv.Itoa(int(ds.DigestType))))
+		if err != nil {
+			panic(err)
 		}
 	}
-	self.access.Unlock()
 }
 
 func AlgorithmName(alg uint8) string {
@@ -64,52 +94,151 @@ func DigestTypeName(dt uint8) string {
 	return str
 }
 
+// splitKey returns domain, algorithm and digest type of a measurement key
+func splitKey(key string) (string, uint8, uint8) {
+	parts := spill.Split(key)
+	alg, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		panic(err)
+	}
+	digest, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil {
+		panic(err)
+	}
+	return parts[0], uint8(alg), uint8(digest)
+}
+
 func (self *DNSSEC) Done() {
+	self.access.Lock()
+	defer self.access.Unlock()
+
 	// init stats counters
 	self.CountDS = make(map[uint8]map[uint8]uint)
 	self.CountDomDS = make(map[uint8]uint)
 	self.CountDomDnskey = make(map[uint8]uint)
+	self.CountDomSigned = 0
+
+	if self.precision > 0 {
+		for alg := range self.ds {
+			self.CountDS[alg] = make(map[uint8]uint)
+			for digest, sketch := range self.ds[alg] {
+				self.CountDS[alg][digest] = sketch.Count()
+			}
+		}
+		self.CountDomSigned = self.domains.Count()
+		return
+	}
+
+	// compute stats, keys of the same domain are walked together
+	lastdom := ""
+	err := self.measurement.Walk(func(key string) {
+		dom, alg, digest := splitKey(key)
+		if self.CountDomSigned == 0 || dom != lastdom {
+			self.CountDomSigned++
+			lastdom = dom
+		}
 
-	// compute stats
-	for dom := range self.measurement {
-		for alg := range self.measurement[dom] {
-			for digest := range self.measurement[dom][alg] {
-				// CountDS
-				if _, ok := self.CountDS[alg]; !ok {
-					self.CountDS[alg] = make(map[uint8]uint)
-				}
-				if _, ok := self.CountDS[alg][digest]; !ok {
-					self.CountDS[alg][digest] = 1
-				} else {
-					self.CountDS[alg][digest]++
-				}
-
-				// CountDomDS
-				if _, ok := self.CountDomDS[digest]; !ok {
-					self.CountDomDS[digest] = 1
-				} else {
-					self.CountDomDS[digest]++
-				}
-
-				// CountDomDnskey
-				if _, ok := self.CountDomDS[digest]; !ok {
-					self.CountDomDS[digest] = 1
-				} else {
-					self.CountDomDS[digest]++
-				}
+		// CountDS
+		if _, ok := self.CountDS[alg]; !ok {
+			self.CountDS[alg] = make(map[uint8]uint)
+		}
+		if _, ok := self.CountDS[alg][digest]; !ok {
+			self.CountDS[alg][digest] = 1
+		} else {
+			self.CountDS[alg][digest]++
+		}
 
+		// CountDomDS
+		if _, ok := self.CountDomDS[digest]; !ok {
+			self.CountDomDS[digest] = 1
+		} else {
+			self.CountDomDS[digest]++
+		}
+
+		// CountDomDnskey
+		if _, ok := self.CountDomDS[digest]; !ok {
+			self.CountDomDS[digest] = 1
+		} else {
+			self.CountDomDS[digest]++
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	self.measurement.Close()
+}
+
+func (self *DNSSEC) Name() string {
+	return "DNSSEC"
+}
+
+// partialSketches is the state of the plugin in approximate mode
+type partialSketches struct {
+	Domains *hll.Sketch
+	DS      map[uint8]map[uint8]*hll.Sketch
+}
+
+// SavePartial saves the sketches of approximate runs, the measurement keys
+// of exact runs are saved as set.
+func (self *DNSSEC) SavePartial() ([]byte, error) {
+	if self.precision > 0 {
+		return json.Marshal(partialSketches{Domains: self.domains, DS: self.ds})
+	}
+	return json.Marshal(nil)
+}
+
+func (self *DNSSEC) MergePartial(data []byte) error {
+	if self.precision == 0 {
+		return nil
+	}
+	state := partialSketches{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	err = self.domains.Merge(state.Domains)
+	if err != nil {
+		return err
+	}
+	for alg := range state.DS {
+		for digest, sketch := range state.DS[alg] {
+			err = self.dsSketch(alg, digest).Merge(sketch)
+			if err != nil {
+				return err
 			}
 		}
 	}
+	return nil
 }
 
-func (self *DNSSEC) Influx(tld string, source string) string {
-	line := ""
+// Sets returns the measurement keys of exact runs.
+func (self *DNSSEC) Sets() map[string]*spill.Set {
+	if self.precision > 0 {
+		return nil
+	}
+	return map[string]*spill.Set{"measurement": self.measurement}
+}
+
+func (self *DNSSEC) Points(tld string, source string) []outputs.Point {
+	points := make([]outputs.Point, 0)
 	for alg := range self.CountDS {
 		for digest := range self.CountDS[alg] {
-			line = line + fmt.Sprintf("CountDS,tld=%s,source=%s,algorithm=%s,digesttype=%s count=%di\n", tld, source, AlgorithmName(alg), DigestTypeName(digest), self.CountDS[alg][digest])
+			point := outputs.NewPoint("CountDS", tld, source)
+			point.Tags["algorithm"] = AlgorithmName(alg)
+			point.Tags["digesttype"] = DigestTypeName(digest)
+			point.Fields["count"] = int64(self.CountDS[alg][digest])
+			points = append(points, point)
+		}
+	}
+	point := outputs.NewPoint("CountDomSigned", tld, source)
+	point.Fields["value"] = int64(self.CountDomSigned)
+	points = append(points, point)
+
+	// error bound of approximate counts
+	if self.precision > 0 {
+		for _, point := range points {
+			point.Fields["error"] = hll.Error(self.precision)
 		}
 	}
-	line = line + fmt.Sprintf("CountDomSigned,tld=%s,source=%s value=%di\n", tld, source, len(self.measurement))
-	return line
+	return points
 }
@@ -0,0 +1,368 @@
+package external
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/derived"
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Input modes of external plugins
+const (
+	// RECORDS streams every resource record
+	RECORDS = "records"
+	// DELEGATIONS streams every delegation with its name servers and DS records
+	DELEGATIONS = "delegations"
+)
+
+// QUEUE is the number of records buffered for the command, reading the
+// zone waits while the queue is full.
+const QUEUE = 10000
+
+// TIMEOUT is the default time an external plugin may block reading its
+// input or take to finish after all input has been sent.
+const TIMEOUT time.Duration = 60 * time.Second
+
+// Config of an external plugin as read from the configuration file.
+type Config struct {
+	Name    string
+	Command string
+	Args    []string
+	Input   string
+	Timeout uint
+}
+
+// Record is the JSON line sent for every resource record.
+type Record struct {
+	Name  string `json:"name"`
+	TTL   uint32 `json:"ttl"`
+	Class string `json:"class"`
+	Type  string `json:"type"`
+	Rdata string `json:"rdata"`
+}
+
+// Delegation is the JSON line sent for every delegation.
+type Delegation struct {
+	Domain string       `json:"domain"`
+	NS     []string     `json:"ns"`
+	DS     []derived.DS `json:"ds"`
+}
+
+// Measurement is the JSON line an external plugin writes for every result.
+// Fields must be numbers, booleans or strings.
+type Measurement struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+// External runs an external command as plugin. The command reads JSON lines
+// on STDIN and writes measurements as JSON lines to STDOUT. Everything the
+// command writes to STDERR is logged. If the command fails, times out or
+// writes invalid output, the error is logged and none of its measurements
+// are used.
+// Records are received in the order of the zone and queued, one goroutine
+// sends them to the command in this order.
+type External struct {
+	access       sync.Mutex
+	input        sync.Mutex
+	config       Config
+	timeout      time.Duration
+	cmd          *exec.Cmd
+	stdin        *os.File
+	encoder      *json.Encoder
+	records      chan Record
+	fed          sync.WaitGroup
+	output       sync.WaitGroup
+	registry     *derived.Registry
+	measurements []Measurement
+	err          error
+}
+
+// Init starts the external command.
+func Init(config Config) (*External, error) {
+	self := External{}
+	self.config = config
+	if len(self.config.Name) == 0 {
+		self.config.Name = config.Command
+	}
+	switch config.Input {
+	case "":
+		self.config.Input = RECORDS
+	case RECORDS, DELEGATIONS:
+	default:
+		return nil, fmt.Errorf("external plugin %s: unknown input %s", self.config.Name, config.Input)
+	}
+	self.timeout = TIMEOUT
+	if config.Timeout > 0 {
+		self.timeout = time.Duration(config.Timeout) * time.Second
+	}
+	self.measurements = make([]Measurement, 0)
+
+	// a pipe of our own, so writes can time out
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	self.cmd = exec.Command(config.Command, config.Args...)
+	self.cmd.Stdin = stdin
+	stdout, err := self.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := self.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = self.cmd.Start()
+	stdin.Close()
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("external plugin %s: %s", self.config.Name, err)
+	}
+	self.stdin = w
+	self.encoder = json.NewEncoder(w)
+
+	self.output.Add(2)
+	go self.readOutput(stdout)
+	go self.readStderr(stderr)
+	if self.config.Input == RECORDS {
+		self.records = make(chan Record, QUEUE)
+		self.fed.Add(1)
+		go self.feed()
+	}
+	return &self, nil
+}
+
+func (self *External) Name() string {
+	return self.config.Name
+}
+
+// Needs returns the shared datasets needed to send delegations.
+func (self *External) Needs() []string {
+	if self.config.Input == DELEGATIONS {
+		return []string{derived.DomainNS, derived.DomainDS}
+	}
+	return []string{}
+}
+
+func (self *External) Use(registry *derived.Registry) {
+	self.registry = registry
+}
+
+// fail remembers the first error and stops the command.
+// It must be called with access locked.
+func (self *External) fail(err error) {
+	if self.err == nil {
+		self.err = err
+		slog.Error("external plugin failed", "plugin", self.config.Name, "error", err)
+		self.cmd.Process.Kill()
+	}
+}
+
+func (self *External) failed() bool {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return self.err != nil
+}
+
+// send writes one JSON line to the command
+func (self *External) send(v interface{}) {
+	self.input.Lock()
+	defer self.input.Unlock()
+	if self.failed() {
+		return
+	}
+	self.stdin.SetWriteDeadline(time.Now().Add(self.timeout))
+	err := self.encoder.Encode(v)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("command did not read its input for %s", self.timeout)
+		}
+		self.access.Lock()
+		self.fail(err)
+		self.access.Unlock()
+	}
+}
+
+// InOrder marks the plugin to receive the records one by one in the order
+// of the zone.
+func (self *External) InOrder() {}
+
+// Receive queues the record for the command. It blocks while the queue is
+// full, at most until sending times out.
+func (self *External) Receive(rr dns.RR, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if self.config.Input != RECORDS {
+		return
+	}
+	header := rr.Header()
+	self.records <- Record{
+		Name:  header.Name,
+		TTL:   header.Ttl,
+		Class: dns.Class(header.Class).String(),
+		Type:  dns.Type(header.Rrtype).String(),
+		Rdata: strings.TrimSpace(strings.TrimPrefix(rr.String(), header.String())),
+	}
+}
+
+// feed sends the queued records to the command. After a failure the
+// records are dropped, so Receive never blocks for long.
+func (self *External) feed() {
+	defer self.fed.Done()
+	for record := range self.records {
+		self.send(record)
+	}
+}
+
+// sendDelegations sends all delegations with their name servers and DS records
+func (self *External) sendDelegations() error {
+	return self.registry.WalkDelegations(func(domain string, hosts []string, ds []derived.DS) {
+		self.send(Delegation{Domain: domain, NS: hosts, DS: ds})
+	})
+}
+
+// Done sends the delegations (if needed), closes the input of the
+// command and waits for it to finish.
+func (self *External) Done() {
+	if self.config.Input == RECORDS {
+		close(self.records)
+		self.fed.Wait()
+	}
+	if self.config.Input == DELEGATIONS {
+		err := self.sendDelegations()
+		if err != nil {
+			panic(err)
+		}
+	}
+	self.input.Lock()
+	self.stdin.Close()
+	self.input.Unlock()
+
+	// wait for the command, but not forever
+	finished := make(chan error, 1)
+	go func() {
+		self.output.Wait()
+		finished <- self.cmd.Wait()
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			self.access.Lock()
+			self.fail(err)
+			self.access.Unlock()
+		}
+	case <-time.After(self.timeout):
+		self.access.Lock()
+		self.fail(fmt.Errorf("command did not finish within %s", self.timeout))
+		self.access.Unlock()
+	}
+}
+
+func (self *External) readOutput(stdout io.Reader) {
+	defer self.output.Done()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		measurement, err := parseMeasurement(scanner.Bytes())
+		self.access.Lock()
+		if err != nil {
+			self.fail(fmt.Errorf("invalid output in line %d: %s", line, err))
+		} else {
+			self.measurements = append(self.measurements, measurement)
+		}
+		self.access.Unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		self.access.Lock()
+		self.fail(fmt.Errorf("reading output after line %d: %s", line, err))
+		self.access.Unlock()
+	}
+	// keep reading, a command blocked on writing would never finish
+	io.Copy(io.Discard, stdout)
+}
+
+func (self *External) readStderr(stderr io.Reader) {
+	defer self.output.Done()
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		slog.Warn("external plugin", "plugin", self.config.Name, "stderr", scanner.Text())
+	}
+}
+
+func parseMeasurement(data []byte) (Measurement, error) {
+	measurement := Measurement{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&measurement)
+	if err != nil {
+		return measurement, err
+	}
+	if len(measurement.Measurement) == 0 {
+		return measurement, errors.New("measurement name missing")
+	}
+	if len(measurement.Fields) == 0 {
+		return measurement, errors.New("measurement without fields")
+	}
+	// numbers become int64 or float64 fields
+	for key, value := range measurement.Fields {
+		switch value := value.(type) {
+		case json.Number:
+			if strings.ContainsAny(value.String(), ".eE") {
+				measurement.Fields[key], err = value.Float64()
+			} else {
+				measurement.Fields[key], err = value.Int64()
+			}
+			if err != nil {
+				return measurement, fmt.Errorf("field %s: %s", key, err)
+			}
+		case bool, string:
+		default:
+			return measurement, fmt.Errorf("field %s must be a number, boolean or string", key)
+		}
+	}
+	for key := range measurement.Tags {
+		if key == "tld" || key == "source" {
+			return measurement, fmt.Errorf("tag %s is reserved", key)
+		}
+	}
+	return measurement, nil
+}
+
+func (self *External) Points(tld string, source string) []outputs.Point {
+	self.access.Lock()
+	defer self.access.Unlock()
+	if self.err != nil {
+		return nil
+	}
+	points := make([]outputs.Point, 0, len(self.measurements))
+	for _, measurement := range self.measurements {
+		point := outputs.NewPoint(measurement.Measurement, tld, source)
+		for key, value := range measurement.Tags {
+			point.Tags[key] = value
+		}
+		for key, value := range measurement.Fields {
+			point.Fields[key] = value
+		}
+		points = append(points, point)
+	}
+	return points
+}
@@ -0,0 +1,218 @@
+package lame
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/derived"
+	"github.com/ulrichwisser/zonestats/dnsresolver"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/sample"
+)
+
+// Outcomes of the check of a delegation
+const (
+	// OK all name servers answer authoritatively
+	OK = "ok"
+	// PARTLY some name servers do not answer authoritatively
+	PARTLY = "partly_lame"
+	// LAME none of the name servers answers authoritatively
+	LAME = "lame"
+)
+
+// Strata of the sample
+const (
+	SIGNED   = "signed"
+	UNSIGNED = "unsigned"
+	ALL      = "all"
+)
+
+// Lame checks if the name servers of delegations answer authoritatively.
+// As this needs queries to every name server of every delegation, only a
+// sample of the delegations is checked and the results are extrapolated.
+type Lame struct {
+	access   sync.Mutex
+	results  map[string]string
+	registry *derived.Registry
+	sampler  *sample.Sampler
+	stratify bool
+	estimate *sample.Estimate
+}
+
+// Init creates the plugin. With stratify the sample is stratified by the
+// DNSSEC status of the delegations.
+func Init(sampler *sample.Sampler, stratify bool) *Lame {
+	self := Lame{}
+	self.sampler = sampler
+	self.stratify = stratify
+	self.estimate = sample.NewEstimate()
+	self.results = make(map[string]string)
+	return &self
+}
+
+func (self *Lame) Needs() []string {
+	return []string{derived.HostIPs, derived.DomainNS, derived.DomainDS}
+}
+
+func (self *Lame) Use(registry *derived.Registry) {
+	self.registry = registry
+}
+
+func (self *Lame) Name() string {
+	return "Lame"
+}
+
+// Receive does nothing, all data is collected by the registry.
+func (self *Lame) Receive(rr dns.RR, wg *sync.WaitGroup) {
+	defer wg.Done()
+}
+
+// addresses returns the addresses of a host, resolved addresses are preferred over glue
+func (self *Lame) addresses(hostname string) []net.IP {
+	host := self.registry.Hosts().GetHost(hostname)
+	if host == nil {
+		return nil
+	}
+	host.Access.Lock()
+	defer host.Access.Unlock()
+	if len(host.IPs) > 0 {
+		return host.IPs
+	}
+	return host.Glue
+}
+
+// authoritative reports if any address of a host answers authoritatively for domain
+func (self *Lame) authoritative(domain string, hostname string) bool {
+	for _, ip := range self.addresses(hostname) {
+		r, err := dnsresolver.Query(domain, dns.TypeSOA, ip.String())
+		if err != nil || r == nil {
+			continue
+		}
+		if r.Authoritative && r.Rcode == dns.RcodeSuccess {
+			return true
+		}
+	}
+	return false
+}
+
+// delegation is a sampled delegation waiting to be checked
+type delegation struct {
+	stratum string
+	domain  string
+	hosts   []string
+}
+
+func (self *Lame) check(stratum string, domain string, hosts []string) {
+	// domains found in the checkpoint are not checked again
+	self.access.Lock()
+	outcome, ok := self.results[domain]
+	self.access.Unlock()
+	if ok {
+		self.estimate.AddSample(stratum, outcome)
+		return
+	}
+
+	answering := 0
+	for _, host := range hosts {
+		if self.authoritative(domain, host) {
+			answering++
+		}
+	}
+	outcome = PARTLY
+	if answering == len(hosts) {
+		outcome = OK
+	}
+	if answering == 0 {
+		outcome = LAME
+	}
+	self.access.Lock()
+	self.results[domain] = outcome
+	self.access.Unlock()
+	self.estimate.AddSample(stratum, outcome)
+}
+
+// SaveCache saves the outcomes of all domains checked so far.
+func (self *Lame) SaveCache() ([]byte, error) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return json.Marshal(self.results)
+}
+
+func (self *Lame) LoadCache(data []byte) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return json.Unmarshal(data, &self.results)
+}
+
+// SavePartial saves no state, all data is kept by the registry.
+func (self *Lame) SavePartial() ([]byte, error) {
+	return json.Marshal(nil)
+}
+
+func (self *Lame) MergePartial(data []byte) error {
+	return nil
+}
+
+func (self *Lame) Done() {
+	if self.registry.Offline() {
+		slog.Warn("lame delegation check needs the network, skipped in offline mode")
+		return
+	}
+	// the workers are limited to the concurrent queries of the resolver
+	var wg sync.WaitGroup
+	work := make(chan delegation, dnsresolver.RATELIMIT)
+	for i := uint(0); i < dnsresolver.RATELIMIT; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range work {
+				self.check(d.stratum, d.domain, d.hosts)
+			}
+		}()
+	}
+	zone := dns.Fqdn(self.registry.Origin())
+	err := self.registry.WalkDelegations(func(domain string, hosts []string, ds []derived.DS) {
+		// the zone apex is not a delegation
+		if len(hosts) == 0 || strings.EqualFold(dns.Fqdn(domain), zone) {
+			return
+		}
+		stratum := ALL
+		if self.stratify {
+			stratum = UNSIGNED
+			if len(ds) > 0 {
+				stratum = SIGNED
+			}
+		}
+		self.estimate.AddPopulation(stratum)
+		if self.sampler.Selected(domain) {
+			work <- delegation{stratum: stratum, domain: domain, hosts: hosts}
+		}
+	})
+	close(work)
+	wg.Wait()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (self *Lame) Points(tld string, source string) []outputs.Point {
+	if self.registry.Offline() {
+		return nil
+	}
+	point := outputs.NewPoint("LameDelegations", tld, source)
+	point.Fields["population"] = int64(self.estimate.Population())
+	point.Fields["sample"] = int64(self.estimate.Sampled())
+	point.Fields["rate"] = self.sampler.Rate()
+	for _, outcome := range []string{OK, PARTLY, LAME} {
+		total, low, high, sampled := self.estimate.Total(outcome)
+		point.Fields[outcome] = total
+		point.Fields[outcome+"_low"] = low
+		point.Fields[outcome+"_high"] = high
+		point.Fields[outcome+"_sample"] = int64(sampled)
+	}
+	return []outputs.Point{point}
+}
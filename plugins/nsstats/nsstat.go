@@ -1,13 +1,13 @@
 package nsstats
 
 import (
-	"fmt"
+	"encoding/json"
+	"log/slog"
 	"sync"
 
 	"github.com/miekg/dns"
-	"github.com/ulrichwisser/zonestats/dnsresolver"
-	"github.com/ulrichwisser/zonestats/hostlist"
-	"github.com/ulrichwisser/zonestats/iplist"
+	"github.com/ulrichwisser/zonestats/derived"
+	"github.com/ulrichwisser/zonestats/outputs"
 )
 
 type statsType uint
@@ -35,82 +35,41 @@ const STATS_SIZE = 30
 
 type Nsstat struct {
 	access   sync.Mutex
-	hostlist hostlist.Hostlist
-	iplist   iplist.IPlist
-	resolver *dnsresolver.Resolver
+	registry *derived.Registry
 	stats    map[statsType]uint
 }
 
-func Init(origin string, resolver *dnsresolver.Resolver) *Nsstat {
+func Init() *Nsstat {
 	self := Nsstat{}
 	self.access = sync.Mutex{}
-	self.hostlist = hostlist.Hostlist{}
-	self.hostlist.Init(origin)
-	self.iplist = iplist.IPlist{}
-	self.iplist.Init()
-	self.resolver = resolver
 	return &self
 }
 
-func (self *Nsstat) GetIPs(host *hostlist.Host, wg *sync.WaitGroup) {
-	defer wg.Done()
-	self.access.Lock()
-	self.access.Unlock()
-	answer := self.resolver.Resolv(host.GetName(), dns.TypeA)
-	answer = append(answer, self.resolver.Resolv(host.GetName(), dns.TypeAAAA)...)
-	for _, answer := range answer {
-		if answer.Header().Rrtype == dns.TypeA {
-			host.AddIP(answer.(*dns.A).A)
-			self.iplist.AddIP(answer.(*dns.A).A, wg)
-		}
-		if answer.Header().Rrtype == dns.TypeAAAA {
-			host.AddIP(answer.(*dns.AAAA).AAAA)
-			self.iplist.AddIP(answer.(*dns.AAAA).AAAA, wg)
-		}
-	}
+// Needs returns the shared datasets the plugin depends on. Only hosts are
+// counted, so the statistics are exact in approximate runs too, the
+// HostIPs dataset grows with the number of hosts, not of delegations.
+func (self *Nsstat) Needs() []string {
+	return []string{derived.HostIPs}
+}
+
+// Use hands over the registry the shared datasets are read from.
+func (self *Nsstat) Use(registry *derived.Registry) {
+	self.registry = registry
+}
+
+// Offline reports if the plugin runs without resolved data.
+// In offline mode all statistics depending on resolved addresses are omitted.
+func (self *Nsstat) Offline() bool {
+	return self.registry.Offline()
 }
 
+// Receive does nothing, all data is collected by the registry.
 func (self *Nsstat) Receive(rr dns.RR, wg *sync.WaitGroup) {
 	defer wg.Done()
-
-	switch rr.(type) {
-	case *dns.NS:
-		hostname := rr.(*dns.NS).Ns
-		domain := rr.Header().Name
-		host := self.hostlist.GetHost(hostname)
-		if host == nil {
-			host = self.hostlist.AddHost(hostname)
-			wg.Add(1)
-			go self.GetIPs(host, wg)
-		}
-		host.AddDomain(domain)
-	case *dns.A:
-		hostname := rr.Header().Name
-		host := self.hostlist.GetHost(hostname)
-		if host == nil {
-			host = self.hostlist.AddHost(hostname)
-			wg.Add(1)
-			go self.GetIPs(host, wg)
-		}
-		glue := rr.(*dns.A).A
-		host.AddGlue(glue)
-		self.iplist.AddIP(glue, wg)
-	case *dns.AAAA:
-		hostname := rr.Header().Name
-		host := self.hostlist.GetHost(hostname)
-		if host == nil {
-			host = self.hostlist.AddHost(hostname)
-			wg.Add(1)
-			go self.GetIPs(host, wg)
-		}
-		glue := rr.(*dns.AAAA).AAAA
-		host.AddGlue(glue)
-		self.iplist.AddIP(glue, wg)
-	}
 }
 
 func (self *Nsstat) HostStats() {
-	for _, host := range self.hostlist.List {
+	for _, host := range self.registry.Hosts().List {
 		if host.IsTldHost {
 			self.stats[InTld]++
 			if len(host.Glue) > 0 {
@@ -176,8 +135,8 @@ func (self *Nsstat) HostStats() {
 }
 
 func (self *Nsstat) IpStats() {
-	for i, cap := range self.iplist.Results {
-		fmt.Printf("%3d: IP %-15s  EDNS0 %t COOKIES %t NSID %-15s BIND %-15s\n", i, cap.Ip.String(), cap.EDNS0, cap.DNSCookies, cap.NSID, cap.BINDVERSION)
+	for _, cap := range self.registry.IPs().Results {
+		slog.Debug("server capabilities", "ip", cap.Ip.String(), "edns0", cap.EDNS0, "cookies", cap.DNSCookies, "nsid", cap.NSID, "bind", cap.BINDVERSION)
 	}
 }
 
@@ -190,17 +149,31 @@ func (self *Nsstat) Done() {
 	self.IpStats()
 }
 
-func (self *Nsstat) Influx(tld string, source string) string {
-	line := fmt.Sprintf("Hosts,tld=%s,source=%s ", tld, source)
-	line = line + fmt.Sprintf("InTld=%di", self.stats[InTld])
-	line = line + fmt.Sprintf(",InTldNoGlue=%di", self.stats[InTldNoGlue])
-	line = line + fmt.Sprintf(",InTldNoGlueNoIp=%di", self.stats[InTldNoGlueNoIp])
-	line = line + fmt.Sprintf(",InTldGlue=%di", self.stats[InTldGlue])
-	line = line + fmt.Sprintf(",InTldGlueNoIp=%di", self.stats[InTldGlueNoIp])
-	line = line + fmt.Sprintf(",InTldGlueIp=%di", self.stats[InTldGlueIp])
-	line = line + fmt.Sprintf(",InTldGlueIpMissmatch=%di", self.stats[InTldGlueIpMissmatch])
-	line = line + fmt.Sprintf(",ExTld=%di", self.stats[ExTld])
-	line = line + fmt.Sprintf(",ExTldNoIp=%di", self.stats[ExTldNoIp])
-	line = line + "\n"
-	return line
+func (self *Nsstat) Name() string {
+	return "Nsstat"
+}
+
+// SavePartial saves no state, all data is kept by the registry.
+func (self *Nsstat) SavePartial() ([]byte, error) {
+	return json.Marshal(nil)
+}
+
+func (self *Nsstat) MergePartial(data []byte) error {
+	return nil
+}
+
+func (self *Nsstat) Points(tld string, source string) []outputs.Point {
+	point := outputs.NewPoint("Hosts", tld, source)
+	point.Fields["InTld"] = int64(self.stats[InTld])
+	point.Fields["InTldNoGlue"] = int64(self.stats[InTldNoGlue])
+	point.Fields["InTldGlue"] = int64(self.stats[InTldGlue])
+	point.Fields["ExTld"] = int64(self.stats[ExTld])
+	if !self.Offline() {
+		point.Fields["InTldNoGlueNoIp"] = int64(self.stats[InTldNoGlueNoIp])
+		point.Fields["InTldGlueNoIp"] = int64(self.stats[InTldGlueNoIp])
+		point.Fields["InTldGlueIp"] = int64(self.stats[InTldGlueIp])
+		point.Fields["InTldGlueIpMissmatch"] = int64(self.stats[InTldGlueIpMissmatch])
+		point.Fields["ExTldNoIp"] = int64(self.stats[ExTldNoIp])
+	}
+	return []outputs.Point{point}
 }
@@ -1,11 +1,12 @@
 package unregns
 
 import (
-	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/outputs"
 )
 
 type Domain struct {
@@ -59,11 +60,11 @@ func (self *UnRegNS) hostNotFound(domain string, host string) {
 
 func (self *UnRegNS) Done() {
 	self.results = make(map[string]Domain, 0)
-	fmt.Printf("CMP  DOMAINS %d   HOSTS %d\n", len(self.domainlist), len(self.hostlist))
+	slog.Debug("comparing hosts to domains", "domains", len(self.domainlist), "hosts", len(self.hostlist))
 	for host := range self.hostlist {
 		found := false
 		for domain := range self.domainlist {
-			fmt.Printf("CMP %s %s\n", host, domain)
+			slog.Debug("compare", "host", host, "domain", domain)
 			if host == domain {
 				found = true
 				break
@@ -74,7 +75,7 @@ func (self *UnRegNS) Done() {
 			}
 		}
 		if !found {
-			fmt.Printf("UNREGNS %s\n", host)
+			slog.Info("unregistered name server", "host", host)
 		}
 	}
 }
@@ -82,14 +83,11 @@ func (self *UnRegNS) Done() {
 func (self *UnRegNS) Stats() {
 	for domain := range self.results {
 		for _, host := range self.results[domain].ns {
-			fmt.Printf("UNREGNS  %-25s %-25s\n", domain, host)
+			slog.Info("unregistered name server", "domain", domain, "host", host)
 		}
 	}
-	fmt.Println("")
 }
 
-func (self *UnRegNS) Influx(tld string, source string) string {
-	line := fmt.Sprintf("Hosts,tld=%s,source=%s ", tld, source)
-	line = line + "\n"
-	return line
+func (self *UnRegNS) Points(tld string, source string) []outputs.Point {
+	return nil
 }
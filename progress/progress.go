@@ -0,0 +1,135 @@
+package progress
+
+import (
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/dnsresolver"
+)
+
+// Progress counts the records and bytes read and reports periodically
+// how far the run has come. It also remembers the serial of the first
+// SOA record and how long it took to read all records.
+type Progress struct {
+	records  int64
+	bytes    int64
+	size     int64
+	serial   int64
+	start    time.Time
+	duration time.Duration
+	stop     chan struct{}
+}
+
+func New() *Progress {
+	self := Progress{}
+	self.serial = -1
+	self.start = time.Now()
+	self.stop = make(chan struct{})
+	return &self
+}
+
+// Count passes all records on and counts them.
+func (self *Progress) Count(rrlist <-chan dns.RR) <-chan dns.RR {
+	out := make(chan dns.RR, 10000)
+	go func() {
+		for rr := range rrlist {
+			atomic.AddInt64(&self.records, 1)
+			if soa, ok := rr.(*dns.SOA); ok {
+				atomic.CompareAndSwapInt64(&self.serial, -1, int64(soa.Serial))
+			}
+			out <- rr
+		}
+		self.duration = time.Since(self.start)
+		close(out)
+	}()
+	return out
+}
+
+// Reader counts the bytes read from r. Size is the total number of bytes
+// that will be read, it is used to estimate the remaining time.
+func (self *Progress) Reader(r io.Reader, size int64) io.Reader {
+	atomic.StoreInt64(&self.size, size)
+	return &reader{r: r, progress: self}
+}
+
+type reader struct {
+	r        io.Reader
+	progress *Progress
+}
+
+func (self *reader) Read(p []byte) (int, error) {
+	n, err := self.r.Read(p)
+	atomic.AddInt64(&self.progress.bytes, int64(n))
+	return n, err
+}
+
+// AddBytes counts bytes read by inputs that do not use Reader.
+func (self *Progress) AddBytes(n int) {
+	atomic.AddInt64(&self.bytes, int64(n))
+}
+
+// Serial returns the serial of the first SOA record read, or -1 if none was read.
+func (self *Progress) Serial() int64 {
+	return atomic.LoadInt64(&self.serial)
+}
+
+// Duration returns the time it took to read all records.
+// It is only valid after all records have been read.
+func (self *Progress) Duration() time.Duration {
+	return self.duration
+}
+
+func (self *Progress) Records() int64 {
+	return atomic.LoadInt64(&self.records)
+}
+
+func (self *Progress) Bytes() int64 {
+	return atomic.LoadInt64(&self.bytes)
+}
+
+// Start reports the progress every interval until Stop is called.
+func (self *Progress) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				self.Report()
+			case <-self.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (self *Progress) Stop() {
+	close(self.stop)
+}
+
+// Report logs the current progress. The remaining time can only be
+// estimated if the size of the input is known.
+func (self *Progress) Report() {
+	elapsed := time.Since(self.start)
+	records := self.Records()
+	rate := float64(records) / elapsed.Seconds()
+	attrs := []any{
+		slog.Int64("records", records),
+		slog.Float64("records_per_sec", float64(int64(rate*10))/10),
+		slog.Int64("resolver_queries", dnsresolver.Outstanding()),
+		slog.Duration("elapsed", elapsed.Round(time.Second)),
+	}
+	size := atomic.LoadInt64(&self.size)
+	bytes := self.Bytes()
+	if size > 0 && bytes > 0 {
+		remaining := time.Duration(float64(elapsed) * float64(size-bytes) / float64(bytes))
+		attrs = append(attrs, slog.Duration("eta", remaining.Round(time.Second)))
+	}
+	slog.Info("progress", attrs...)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ulrichwisser/zonestats/report"
+)
+
+// runReport renders the JSON results of a run as HTML page,
+// "zonestats report --html out.html results.json".
+func runReport(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	html := flags.String("html", "", "file to write the HTML report to")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: zonestats report --html <file> <results.json>|-")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if len(*html) == 0 || flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	document, err := report.Read(flags.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	file, err := os.Create(*html)
+	if err != nil {
+		panic(err)
+	}
+	err = report.HTML(file, document)
+	if err != nil {
+		file.Close()
+		panic(err)
+	}
+	err = file.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,376 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/outputs/export"
+)
+
+// Size of the bar charts in pixels
+const (
+	BARHEIGHT = 22
+	LABELS    = 190
+	BARS      = 420
+	WIDTH     = LABELS + BARS + 90
+)
+
+// HOSTS are the fields of the nsstats measurement Hosts in the order shown
+var HOSTS = []struct {
+	Field       string
+	Description string
+}{
+	{"InTld", "in zone"},
+	{"InTldGlue", "in zone, with glue"},
+	{"InTldGlueIp", "in zone, with glue, resolved"},
+	{"InTldGlueIpMissmatch", "in zone, glue and resolved addresses differ"},
+	{"InTldGlueNoIp", "in zone, with glue, not resolved"},
+	{"InTldNoGlue", "in zone, without glue"},
+	{"InTldNoGlueNoIp", "in zone, without glue, not resolved"},
+	{"ExTld", "out of zone"},
+	{"ExTldNoIp", "out of zone, not resolved"},
+}
+
+// hostClass is a class of name server hosts, the hosts of field Field
+// less the hosts of field Minus
+type hostClass struct {
+	Label string
+	Field string
+	Minus string
+}
+
+// LEAVES are the disjoint classes of name server hosts charted, every host
+// is in exactly one of them. Offline runs have no results of resolving,
+// only the classes of OFFLINE are charted then.
+var LEAVES = []hostClass{
+	{"in zone, glue matches", "InTldGlueIp", "InTldGlueIpMissmatch"},
+	{"in zone, glue differs", "InTldGlueIpMissmatch", ""},
+	{"in zone, glue, no IP", "InTldGlueNoIp", ""},
+	{"in zone, no glue, IP", "InTldNoGlue", "InTldNoGlueNoIp"},
+	{"in zone, no glue, no IP", "InTldNoGlueNoIp", ""},
+	{"out of zone, IP", "ExTld", "ExTldNoIp"},
+	{"out of zone, no IP", "ExTldNoIp", ""},
+}
+
+// OFFLINE are the disjoint classes charted for offline runs
+var OFFLINE = []hostClass{
+	{"in zone, with glue", "InTldGlue", ""},
+	{"in zone, without glue", "InTldNoGlue", ""},
+	{"out of zone", "ExTld", ""},
+}
+
+// Read reads the results of a run as written by the json sink. The
+// filename - reads from STDIN.
+func Read(filename string) (*export.Document, error) {
+	in := os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+	document := &export.Document{}
+	err := json.NewDecoder(in).Decode(document)
+	if err != nil {
+		return nil, err
+	}
+	// the JSON document of schema 1 is the same, only the CSV differs
+	if document.Schema < 1 || document.Schema > export.SCHEMA {
+		return nil, fmt.Errorf("results have schema %d, only 1 to %d can be read", document.Schema, export.SCHEMA)
+	}
+	return document, nil
+}
+
+type bar struct {
+	Label string
+	Value string
+	Y     int
+	Width int
+}
+
+type chart struct {
+	Height int
+	Bars   []bar
+}
+
+type cell struct {
+	Value string
+	Level int
+}
+
+type row struct {
+	Label string
+	Cells []cell
+	Total string
+}
+
+type hostRow struct {
+	Field       string
+	Description string
+	Value       string
+}
+
+type page struct {
+	Run        export.Run
+	Serial     string
+	Finished   string
+	Domains    string
+	Signed     string
+	RRTypes    chart
+	RRTotal    string
+	Digests    []string
+	DS         []row
+	Hosts      chart
+	HostsIn    string
+	HostsEx    string
+	HostsTotal string
+	HostRows   []hostRow
+}
+
+// HTML renders the results as a single static page. Charts are inline SVG,
+// the page loads nothing from the network. The page only depends on the
+// results, the same results always give the same page.
+func HTML(out io.Writer, document *export.Document) error {
+	data := page{Run: document.Run, Finished: document.Run.End.UTC().Format(time.RFC3339)}
+	if document.Run.Serial != nil {
+		data.Serial = strconv.FormatInt(*document.Run.Serial, 10)
+	}
+	if fields := sum(document, "CountDom"); len(fields) > 0 {
+		data.Domains = format(fields["value"])
+	}
+	if fields := sum(document, "CountDomSigned"); len(fields) > 0 {
+		data.Signed = format(fields["value"])
+	}
+
+	// record types, the most frequent first
+	rrtypes := sum(document, "CountRR")
+	labels := make([]string, 0, len(rrtypes))
+	values := make([]float64, 0, len(rrtypes))
+	var total float64
+	for rrtype := range rrtypes {
+		labels = append(labels, rrtype)
+		total += rrtypes[rrtype]
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if rrtypes[labels[i]] != rrtypes[labels[j]] {
+			return rrtypes[labels[i]] > rrtypes[labels[j]]
+		}
+		return labels[i] < labels[j]
+	})
+	for _, rrtype := range labels {
+		values = append(values, rrtypes[rrtype])
+	}
+	data.RRTypes = barChart(labels, values)
+	data.RRTotal = format(total)
+
+	data.Digests, data.DS = dsMatrix(document)
+
+	// name server hosts, the chart has the disjoint classes only, the table
+	// all fields in the order of HOSTS, fields not written are left out
+	hosts := sum(document, "Hosts")
+	leaves := LEAVES
+	if _, ok := hosts["InTldGlueIp"]; !ok {
+		leaves = OFFLINE
+	}
+	labels = make([]string, 0, len(leaves))
+	values = make([]float64, 0, len(leaves))
+	for _, leaf := range leaves {
+		value, ok := hosts[leaf.Field]
+		if !ok {
+			continue
+		}
+		labels = append(labels, leaf.Label)
+		values = append(values, value-hosts[leaf.Minus])
+	}
+	data.Hosts = barChart(labels, values)
+	if len(hosts) > 0 {
+		data.HostsIn = format(hosts["InTld"])
+		data.HostsEx = format(hosts["ExTld"])
+		data.HostsTotal = format(hosts["InTld"] + hosts["ExTld"])
+	}
+	for _, host := range HOSTS {
+		if value, ok := hosts[host.Field]; ok {
+			data.HostRows = append(data.HostRows, hostRow{Field: host.Field, Description: host.Description, Value: format(value)})
+		}
+	}
+
+	return pageTemplate.Execute(out, data)
+}
+
+// sum returns the numeric fields of all points of a measurement, the fields
+// of several points (e.g. with different tags) are summed
+func sum(document *export.Document, measurement string) map[string]float64 {
+	fields := make(map[string]float64)
+	for _, point := range document.Points {
+		if point.Measurement != measurement {
+			continue
+		}
+		for field, value := range point.Fields {
+			if number, ok := outputs.Number(value); ok {
+				fields[field] += number
+			}
+		}
+	}
+	return fields
+}
+
+// dsMatrix returns the digest types and one row per algorithm with the
+// number of DS records of every digest type
+func dsMatrix(document *export.Document) ([]string, []row) {
+	counts := make(map[string]map[string]float64)
+	digests := make(map[string]bool)
+	var max float64
+	for _, point := range document.Points {
+		if point.Measurement != "CountDS" {
+			continue
+		}
+		count, ok := outputs.Number(point.Fields["count"])
+		if !ok {
+			continue
+		}
+		algorithm := point.Tags["algorithm"]
+		digest := point.Tags["digesttype"]
+		if counts[algorithm] == nil {
+			counts[algorithm] = make(map[string]float64)
+		}
+		counts[algorithm][digest] += count
+		digests[digest] = true
+		max = math.Max(max, counts[algorithm][digest])
+	}
+
+	digestlist := sortedKeys(digests)
+	algorithms := make(map[string]bool)
+	for algorithm := range counts {
+		algorithms[algorithm] = true
+	}
+	rows := make([]row, 0, len(counts))
+	for _, algorithm := range sortedKeys(algorithms) {
+		r := row{Label: algorithm}
+		var total float64
+		for _, digest := range digestlist {
+			count := counts[algorithm][digest]
+			total += count
+			level := 0
+			if count > 0 {
+				level = int(math.Ceil(4 * count / max))
+			}
+			r.Cells = append(r.Cells, cell{Value: format(count), Level: level})
+		}
+		r.Total = format(total)
+		rows = append(rows, r)
+	}
+	return digestlist, rows
+}
+
+// barChart returns horizontal bars scaled to the largest value
+func barChart(labels []string, values []float64) chart {
+	var max float64
+	for _, value := range values {
+		max = math.Max(max, value)
+	}
+	c := chart{Height: len(labels) * BARHEIGHT}
+	for i, label := range labels {
+		width := 0
+		if max > 0 {
+			width = int(math.Round(BARS * values[i] / max))
+		}
+		c.Bars = append(c.Bars, bar{Label: label, Value: format(values[i]), Y: i * BARHEIGHT, Width: width})
+	}
+	return c
+}
+
+func format(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var pageTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>zonestats {{.Run.Zone}} {{.Serial}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.meta td, .meta th { border: none; padding: 0.1em 0.8em 0.1em 0; }
+svg text { font-size: 13px; }
+.bar { fill: #3a6ea5; }
+.l0 { background: #fff; }
+.l1 { background: #dbe7f3; }
+.l2 { background: #a9c6e3; }
+.l3 { background: #6f9fd0; }
+.l4 { background: #3a6ea5; color: #fff; }
+td.text { text-align: left; }
+.none { color: #888; }
+</style>
+</head>
+<body>
+<h1>zonestats report for {{.Run.Zone}}</h1>
+<table class="meta">
+<tr><th>Zone</th><td>{{.Run.Zone}}</td></tr>
+<tr><th>Serial</th><td>{{if .Serial}}{{.Serial}}{{else}}unknown{{end}}</td></tr>
+<tr><th>Results of</th><td>{{.Run.Time.UTC.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Source</th><td>{{.Run.Source}} {{.Run.Input}}</td></tr>
+{{if .Domains}}<tr><th>Domains</th><td>{{.Domains}}</td></tr>{{end}}
+{{if .Signed}}<tr><th>Signed domains</th><td>{{.Signed}}</td></tr>{{end}}
+</table>
+
+<h2>Resource record types</h2>
+{{if .RRTypes.Bars}}
+{{template "chart" .RRTypes}}
+<p>{{.RRTotal}} records in total.</p>
+{{else}}<p class="none">No results of CountRR.</p>{{end}}
+
+<h2>DS algorithms and digest types</h2>
+{{if .DS}}
+<table>
+<tr><th>Algorithm</th>{{range .Digests}}<th>{{.}}</th>{{end}}<th>Total</th></tr>
+{{range .DS}}<tr><td>{{.Label}}</td>{{range .Cells}}<td class="l{{.Level}}">{{.Value}}</td>{{end}}<td>{{.Total}}</td></tr>
+{{end}}
+</table>
+{{else}}<p class="none">No results of CountDS.</p>{{end}}
+
+<h2>Name server hosts</h2>
+{{if .Hosts.Bars}}
+{{template "chart" .Hosts}}
+<p>{{.HostsTotal}} hosts in total, {{.HostsIn}} in zone (InTld) and {{.HostsEx}} out of zone (ExTld).</p>
+<table>
+<tr><th>Field</th><th>Hosts</th><th>Description</th></tr>
+{{range .HostRows}}<tr><td>{{.Field}}</td><td>{{.Value}}</td><td class="text">{{.Description}}</td></tr>
+{{end}}
+</table>
+{{else}}<p class="none">No results of nsstats.</p>{{end}}
+
+<p class="none">Run finished {{.Finished}}, report generated by zonestats.</p>
+</body>
+</html>
+{{define "chart"}}<svg xmlns="http://www.w3.org/2000/svg" width="` + strconv.Itoa(WIDTH) + `" height="{{.Height}}" role="img">
+{{range .Bars}}<g transform="translate(0,{{.Y}})">
+<text x="` + strconv.Itoa(LABELS-8) + `" y="15" text-anchor="end">{{.Label}}</text>
+<rect class="bar" x="` + strconv.Itoa(LABELS) + `" y="3" width="{{.Width}}" height="` + strconv.Itoa(BARHEIGHT-6) + `"></rect>
+<text x="{{.Width}}" dx="` + strconv.Itoa(LABELS+6) + `" y="15">{{.Value}}</text>
+</g>
+{{end}}</svg>{{end}}
+`))
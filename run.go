@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/canonical"
+	"github.com/ulrichwisser/zonestats/dnsresolver"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/progress"
+)
+
+// runStats is the self telemetry of a run. It is written as measurement
+// ZonestatsRun, so changes in the plugin results can be told apart from
+// problems of the run itself (e.g. a truncated transfer).
+type runStats struct {
+	input    string
+	serial   int64
+	start    time.Time
+	progress *progress.Progress
+	receive  []int64
+	done     []time.Duration
+}
+
+var stats = runStats{}
+
+func initRunStats(config *Configuration, progress *progress.Progress) {
+	stats.input = config.Filename
+	if config.Source == "axfr" {
+		stats.input = config.Axfr
+	}
+	stats.serial = -1
+	stats.start = time.Now()
+	stats.progress = progress
+	stats.receive = make([]int64, len(plugins))
+	stats.done = make([]time.Duration, len(plugins))
+}
+
+// addReceive adds the time spent in Receive of plugin i
+func (self *runStats) addReceive(i int, d time.Duration) {
+	atomic.AddInt64(&self.receive[i], int64(d))
+}
+
+func (self *runStats) setDone(i int, d time.Duration) {
+	self.done[i] = d
+}
+
+// Serial returns the SOA serial of the zone, or -1 if unknown
+func (self *runStats) Serial() int64 {
+	if self.progress != nil {
+		return self.progress.Serial()
+	}
+	return self.serial
+}
+
+// Run returns the metadata of the run
+func (self *runStats) Run(config *Configuration, timestamp time.Time) outputs.Run {
+	return outputs.Run{
+		Zone:   config.Zone,
+		Source: config.Source,
+		Input:  self.input,
+		Serial: self.Serial(),
+		Time:   timestamp,
+		Start:  self.start,
+		End:    time.Now(),
+	}
+}
+
+// pluginName returns the name of a plugin for tagging
+func pluginName(plugin Plugin) string {
+	if named, ok := plugin.(interface{ Name() string }); ok {
+		return named.Name()
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", plugin), "*")
+}
+
+func (self *runStats) Points(tld string, source string) []outputs.Point {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	queries, failures := dnsresolver.Queries()
+
+	points := make([]outputs.Point, 0, len(plugins)+1)
+	point := outputs.NewPoint("ZonestatsRun", tld, source)
+	point.Fields["input"] = self.input
+	if self.Serial() >= 0 {
+		point.Fields["serial"] = self.Serial()
+	}
+	if self.progress != nil {
+		point.Fields["records"] = self.progress.Records()
+		// for AXFR the sum of the uncompressed length (dns.Len) of the
+		// records, not the bytes received
+		point.Fields["bytes"] = self.progress.Bytes()
+		point.Fields["read_seconds"] = self.progress.Duration().Seconds()
+		point.Fields["noncanonical"] = canonical.Changed()
+	}
+	point.Fields["resolver_queries"] = queries
+	point.Fields["resolver_failures"] = failures
+	// memory obtained from the OS at the end of the run, heap and stacks
+	// returned to the OS are still included
+	point.Fields["sys_memory"] = int64(memory.Sys)
+	points = append(points, point)
+
+	for i, plugin := range plugins {
+		point := outputs.NewPoint("ZonestatsRun", tld, source)
+		point.Tags["plugin"] = pluginName(plugin)
+		point.Fields["receive_seconds"] = time.Duration(atomic.LoadInt64(&self.receive[i])).Seconds()
+		point.Fields["done_seconds"] = self.done[i].Seconds()
+		points = append(points, point)
+	}
+	return points
+}
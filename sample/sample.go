@@ -0,0 +1,161 @@
+package sample
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Z is the quantile of the normal distribution for 95% confidence intervals.
+const Z = 1.96
+
+// Sampler selects a reproducible random sample of names. Every name is
+// selected independently with probability rate. The selection only depends
+// on seed and name, so runs with the same seed check the same domains.
+type Sampler struct {
+	rate float64
+	seed int64
+}
+
+func New(rate float64, seed int64) *Sampler {
+	self := Sampler{}
+	self.rate = rate
+	self.seed = seed
+	return &self
+}
+
+func (self *Sampler) Rate() float64 {
+	return self.rate
+}
+
+// Selected reports if a name is part of the sample.
+func (self *Sampler) Selected(name string) bool {
+	if self.rate >= 1 {
+		return true
+	}
+	hash := fnv.New64a()
+	seed := make([]byte, 8)
+	binary.BigEndian.PutUint64(seed, uint64(self.seed))
+	hash.Write(seed)
+	hash.Write([]byte(strings.ToLower(name)))
+	return float64(hash.Sum64()>>11)/float64(1<<53) < self.rate
+}
+
+type stratum struct {
+	population uint
+	sampled    uint
+	outcomes   map[string]uint
+}
+
+// Estimate extrapolates outcomes of a sample to the whole population.
+// With several strata the estimate is post-stratified: the sample is drawn
+// with the same rate from all strata and only afterwards divided into them,
+// so the sample size of each stratum is random. The strata are weighted by
+// their population, which gives narrower confidence intervals when the
+// outcomes differ between strata. The variance is that of a stratified
+// sample with the sizes observed, which is a good approximation as long as
+// every stratum has enough sampled members.
+type Estimate struct {
+	access sync.Mutex
+	strata map[string]*stratum
+}
+
+func NewEstimate() *Estimate {
+	self := Estimate{}
+	self.strata = make(map[string]*stratum)
+	return &self
+}
+
+func (self *Estimate) stratum(name string) *stratum {
+	if _, ok := self.strata[name]; !ok {
+		self.strata[name] = &stratum{outcomes: make(map[string]uint)}
+	}
+	return self.strata[name]
+}
+
+// AddPopulation counts a member of the population.
+func (self *Estimate) AddPopulation(stratum string) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	self.stratum(stratum).population++
+}
+
+// AddSample counts a member of the sample with its outcome.
+func (self *Estimate) AddSample(stratum string, outcome string) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	s := self.stratum(stratum)
+	s.sampled++
+	s.outcomes[outcome]++
+}
+
+func (self *Estimate) Population() uint {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var n uint
+	for _, s := range self.strata {
+		n += s.population
+	}
+	return n
+}
+
+func (self *Estimate) Sampled() uint {
+	self.access.Lock()
+	defer self.access.Unlock()
+	var n uint
+	for _, s := range self.strata {
+		n += s.sampled
+	}
+	return n
+}
+
+// Outcomes returns all outcomes seen in the sample, sorted.
+func (self *Estimate) Outcomes() []string {
+	self.access.Lock()
+	defer self.access.Unlock()
+	seen := make(map[string]bool)
+	for _, s := range self.strata {
+		for outcome := range s.outcomes {
+			seen[outcome] = true
+		}
+	}
+	outcomes := make([]string, 0, len(seen))
+	for outcome := range seen {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	return outcomes
+}
+
+// Total estimates the number of members of the population with an outcome
+// and returns the 95% confidence interval and the number of sampled members
+// with that outcome. The interval uses the normal approximation with finite
+// population correction and is limited to 0 and the population size.
+// Strata without any sampled member do not contribute to the estimate.
+func (self *Estimate) Total(outcome string) (total float64, low float64, high float64, sampled uint) {
+	self.access.Lock()
+	defer self.access.Unlock()
+	variance := 0.0
+	var population uint
+	for _, s := range self.strata {
+		population += s.population
+		sampled += s.outcomes[outcome]
+		if s.sampled == 0 {
+			continue
+		}
+		N := float64(s.population)
+		n := float64(s.sampled)
+		p := float64(s.outcomes[outcome]) / n
+		total += N * p
+		if n > 1 && N > n {
+			variance += N * N * (1 - n/N) * p * (1 - p) / (n - 1)
+		}
+	}
+	margin := Z * math.Sqrt(variance)
+	low = math.Max(0, total-margin)
+	high = math.Min(float64(population), total+margin)
+	return
+}
@@ -0,0 +1,185 @@
+package shard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/spill"
+)
+
+// VERSION of the partial result file format.
+// Increase whenever the format or the state of any plugin changes.
+const VERSION = 6
+
+// SECTION starts the line with the name of a set in a partial result file.
+// It sorts before any character of a domain name in presentation format,
+// so no key starts with it.
+const SECTION = "\x01"
+
+// Partial is the result of one shard of a sharded run.
+// Precision is the HyperLogLog precision of approximate runs (0 for exact runs).
+// Serial is the SOA serial of the zone (-1 if unknown), Time the timestamp of
+// the results.
+// Plugins holds the serialized state of every plugin by plugin name.
+// Sets are the name sets of the plugins, they may be larger than memory
+// and are written key by key after the other state.
+//
+// The file has the other state as JSON on the first line, followed by a
+// section for every set: a line with SECTION and the name of the set and
+// one line for every key.
+type Partial struct {
+	Version   uint
+	Zone      string
+	Source    string
+	Offline   bool
+	Precision uint
+	Shard     uint
+	Shards    uint
+	Serial    int64
+	Time      time.Time
+	Plugins   map[string]json.RawMessage
+	Sets      map[string]*spill.Set `json:"-"`
+	filename  string
+}
+
+func New(zone string, source string, offline bool, precision uint, shard uint, shards uint) *Partial {
+	self := Partial{}
+	self.Version = VERSION
+	self.Zone = zone
+	self.Source = source
+	self.Offline = offline
+	self.Precision = precision
+	self.Shard = shard
+	self.Shards = shards
+	self.Serial = -1
+	self.Plugins = make(map[string]json.RawMessage)
+	self.Sets = make(map[string]*spill.Set)
+	return &self
+}
+
+// Write saves the partial result to a file.
+func (self *Partial) Write(filename string) error {
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.Write(data)
+	w.WriteByte('\n')
+	names := make([]string, 0, len(self.Sets))
+	for name := range self.Sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.WriteString(SECTION + name + "\n")
+		err = self.Sets[name].Walk(func(key string) {
+			w.WriteString(key)
+			w.WriteByte('\n')
+		})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read loads a partial result from a file. The sets are not read, they
+// are added by ReadSets.
+// Only files of the current format version are accepted.
+func Read(filename string) (*Partial, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	self := &Partial{}
+	err = json.Unmarshal(data, self)
+	if err != nil {
+		return nil, err
+	}
+	if self.Version != VERSION {
+		return nil, fmt.Errorf("%s: partial result version %d not supported (expected %d)", filename, self.Version, VERSION)
+	}
+	self.filename = filename
+	return self, nil
+}
+
+// ReadSets adds the keys of every set of the partial result to the set
+// of the same name. Every set of the file must be given.
+func (self *Partial) ReadSets(sets map[string]*spill.Set) error {
+	f, err := os.Open(self.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+	// skip the other state
+	scanner.Scan()
+	var set *spill.Set
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte(SECTION)) {
+			name := string(line[len(SECTION):])
+			set = sets[name]
+			if set == nil {
+				return fmt.Errorf("%s: unknown set %s", self.filename, name)
+			}
+			continue
+		}
+		if set == nil {
+			return fmt.Errorf("%s: key outside of a set", self.filename)
+		}
+		err = set.Add(string(line))
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Check makes sure a set of partial results belong to the same run
+// and that every shard is present exactly once.
+func Check(partials []*Partial) error {
+	if len(partials) == 0 {
+		return fmt.Errorf("no partial results given")
+	}
+	first := partials[0]
+	seen := make(map[uint]bool)
+	for _, partial := range partials {
+		if partial.Zone != first.Zone || partial.Source != first.Source || partial.Offline != first.Offline || partial.Precision != first.Precision || partial.Shards != first.Shards || partial.Serial != first.Serial {
+			return fmt.Errorf("partial result of shard %d does not belong to the same run as shard %d", partial.Shard, first.Shard)
+		}
+		if seen[partial.Shard] {
+			return fmt.Errorf("shard %d given more than once", partial.Shard)
+		}
+		seen[partial.Shard] = true
+	}
+	for shard := uint(0); shard < first.Shards; shard++ {
+		if !seen[shard] {
+			return fmt.Errorf("shard %d of %d missing", shard, first.Shards)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package shard
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ulrichwisser/zonestats/spill"
+)
+
+func walk(t *testing.T, set *spill.Set) []string {
+	t.Helper()
+	keys := make([]string, 0)
+	err := set.Walk(func(key string) { keys = append(keys, key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// TestSets writes spilled sets of two shards and merges them
+func TestSets(t *testing.T) {
+	oldLimit, oldDir := spill.Limit, spill.Dir
+	spill.Limit, spill.Dir = 5000, t.TempDir()
+	defer func() { spill.Limit, spill.Dir = oldLimit, oldDir }()
+
+	filenames := make([]string, 0)
+	var want []string
+	for shard := uint(0); shard < 2; shard++ {
+		partial := New("se", "file", false, 0, shard, 2)
+		partial.Plugins["A"] = []byte(`{"count":1}`)
+		a, b := spill.New(), spill.New()
+		for i := 0; i < 1000; i++ {
+			a.Add(fmt.Sprintf("a%04d.se.", 2*i+int(shard)))
+			b.Add(spill.Join(fmt.Sprintf("b%04d.se.", i), "ns.se."))
+		}
+		if !a.Spilled() {
+			t.Fatal("set did not spill")
+		}
+		partial.Sets["A.names"] = a
+		partial.Sets["B.names"] = b
+		filename := filepath.Join(t.TempDir(), fmt.Sprintf("%d.partial", shard))
+		if err := partial.Write(filename); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+		want = walk(t, b)
+		a.Close()
+		b.Close()
+	}
+
+	merged := map[string]*spill.Set{"A.names": spill.New(), "B.names": spill.New()}
+	partials := make([]*Partial, 0)
+	for _, filename := range filenames {
+		partial, err := Read(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(partial.Plugins["A"]) != `{"count":1}` {
+			t.Fatalf("state %s", partial.Plugins["A"])
+		}
+		if err := partial.ReadSets(merged); err != nil {
+			t.Fatal(err)
+		}
+		partials = append(partials, partial)
+	}
+	if err := Check(partials); err != nil {
+		t.Fatal(err)
+	}
+	if got := walk(t, merged["A.names"]); len(got) != 2000 || got[0] != "a0000.se." || got[1999] != "a1999.se." {
+		t.Errorf("merged set A has %d keys", len(got))
+	}
+	if got := walk(t, merged["B.names"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("merged set B has %d keys, want %d", len(got), len(want))
+	}
+	if err := partials[0].ReadSets(map[string]*spill.Set{"A.names": spill.New()}); err == nil {
+		t.Error("unknown set accepted")
+	}
+}
@@ -0,0 +1,48 @@
+package shard
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Delegation returns the name of the delegation an owner name belongs to.
+// That is the owner name cut to one label below the origin. Names at or
+// above the origin belong to the origin itself.
+func Delegation(name string, origin string) string {
+	origin = dns.Fqdn(origin)
+	labels := dns.SplitDomainName(name)
+	originlabels := dns.CountLabel(origin)
+	if len(labels) <= originlabels {
+		return origin
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-originlabels-1:], "."))
+}
+
+// Select returns the shard responsible for an owner name.
+// All names of one delegation are handled by the same shard,
+// the zone apex is always handled by shard 0.
+func Select(name string, origin string, shards uint) uint {
+	delegation := strings.ToLower(Delegation(name, origin))
+	if delegation == strings.ToLower(dns.Fqdn(origin)) {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(delegation))
+	return uint(hash.Sum32()) % shards
+}
+
+// Filter passes only the resource records belonging to the given shard.
+func Filter(rrlist <-chan dns.RR, origin string, shard uint, shards uint) <-chan dns.RR {
+	out := make(chan dns.RR, 10000)
+	go func() {
+		for rr := range rrlist {
+			if Select(rr.Header().Name, origin, shards) == shard {
+				out <- rr
+			}
+		}
+		close(out)
+	}()
+	return out
+}
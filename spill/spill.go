@@ -0,0 +1,341 @@
+package spill
+
+import (
+	"bufio"
+	"container/heap"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// OVERHEAD is the estimated memory used by a key in addition to its bytes.
+const OVERHEAD = 64
+
+// Limit is the memory (in bytes) all sets together may use before they spill
+// to disk. A limit of 0 keeps everything in memory.
+var Limit int64 = 0
+
+// Dir is the directory temporary spill files are written to.
+var Dir = os.TempDir()
+
+// MAXRUNS is the number of run files merged at once. Sets with more runs
+// are merged in stages, so the number of open files stays bounded.
+const MAXRUNS = 64
+
+// memory currently used by all sets
+var used int64
+
+// sets holding keys in memory, guarded by balancing
+var (
+	balancing sync.Mutex
+	live      = make(map[*Set]struct{})
+)
+
+// Set is a set of strings with bounded memory use. Keys are kept in memory
+// until all sets together reach the limit, then the largest set is sorted
+// and written to a temporary run file. Walk merges memory and run files, so
+// the result is the same whether the set has spilled or not.
+type Set struct {
+	access sync.Mutex
+	keys   map[string]struct{}
+	size   int64
+	runs   []string
+}
+
+func New() *Set {
+	self := Set{}
+	self.keys = make(map[string]struct{})
+	self.runs = make([]string, 0)
+	return &self
+}
+
+// Add adds a key to the set. Keys must not contain newlines.
+func (self *Set) Add(key string) error {
+	self.access.Lock()
+	if _, ok := self.keys[key]; ok {
+		self.access.Unlock()
+		return nil
+	}
+	self.keys[key] = struct{}{}
+	if self.size == 0 {
+		balancing.Lock()
+		live[self] = struct{}{}
+		balancing.Unlock()
+	}
+	size := int64(len(key) + OVERHEAD)
+	self.size += size
+	over := atomic.AddInt64(&used, size) > Limit && Limit > 0
+	self.access.Unlock()
+	if over {
+		return balance()
+	}
+	return nil
+}
+
+// balance spills the largest sets until the memory used is below the limit.
+// Sets in use (e.g. being walked) are skipped, they are spilled by a later
+// call.
+func balance() error {
+	balancing.Lock()
+	defer balancing.Unlock()
+	for atomic.LoadInt64(&used) > Limit {
+		var largest *Set
+		for set := range live {
+			if !set.access.TryLock() {
+				continue
+			}
+			if largest != nil && largest.size >= set.size {
+				set.access.Unlock()
+				continue
+			}
+			if largest != nil {
+				largest.access.Unlock()
+			}
+			largest = set
+		}
+		if largest == nil {
+			return nil
+		}
+		delete(live, largest)
+		err := largest.spill()
+		largest.access.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Spilled reports if the set has written keys to disk.
+func (self *Set) Spilled() bool {
+	self.access.Lock()
+	defer self.access.Unlock()
+	return len(self.runs) > 0
+}
+
+// spill writes all keys in memory to a new run file
+func (self *Set) spill() error {
+	keys := self.sortedKeys()
+	name, err := writeRun(func(w *bufio.Writer) error {
+		for _, key := range keys {
+			w.WriteString(key)
+			w.WriteByte('\n')
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	self.runs = append(self.runs, name)
+	self.keys = make(map[string]struct{})
+	atomic.AddInt64(&used, -self.size)
+	self.size = 0
+	return nil
+}
+
+// writeRun writes a new run file and returns its name
+func writeRun(fn func(w *bufio.Writer) error) (string, error) {
+	f, err := ioutil.TempFile(Dir, "zonestats-spill-")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	err = fn(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (self *Set) sortedKeys() []string {
+	keys := make([]string, 0, len(self.keys))
+	for key := range self.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Walk calls fn for every distinct key in sorted order.
+func (self *Set) Walk(fn func(key string)) error {
+	self.access.Lock()
+	defer self.access.Unlock()
+
+	// keep one file for the keys in memory
+	for len(self.runs) > MAXRUNS-1 {
+		err := self.compact()
+		if err != nil {
+			return err
+		}
+	}
+
+	// one source for the keys in memory and one for every run file
+	sources := make([]*source, 0, len(self.runs)+1)
+	keys := self.sortedKeys()
+	sources = append(sources, &source{next: func() (string, bool) {
+		if len(keys) == 0 {
+			return "", false
+		}
+		key := keys[0]
+		keys = keys[1:]
+		return key, true
+	}})
+	for _, run := range self.runs {
+		src, f, err := openRun(run)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sources = append(sources, src)
+	}
+	return merge(sources, fn)
+}
+
+// compact merges the first MAXRUNS run files into one
+func (self *Set) compact() error {
+	sources := make([]*source, 0, MAXRUNS)
+	for _, run := range self.runs[:MAXRUNS] {
+		src, f, err := openRun(run)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sources = append(sources, src)
+	}
+	name, err := writeRun(func(w *bufio.Writer) error {
+		return merge(sources, func(key string) {
+			w.WriteString(key)
+			w.WriteByte('\n')
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, run := range self.runs[:MAXRUNS] {
+		os.Remove(run)
+	}
+	self.runs = append(self.runs[MAXRUNS:], name)
+	return nil
+}
+
+// openRun returns a source reading a run file
+func openRun(run string) (*source, *os.File, error) {
+	f, err := os.Open(run)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &source{next: func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		return scanner.Text(), true
+	}, err: scanner.Err}, f, nil
+}
+
+// merge calls fn for the keys of all sorted sources in sorted order, keys
+// found in more than one source only once
+func merge(sources []*source, fn func(key string)) error {
+	active := make(mergeHeap, 0, len(sources))
+	for _, src := range sources {
+		if src.advance() {
+			active = append(active, src)
+		}
+	}
+	heap.Init(&active)
+	last := ""
+	first := true
+	for active.Len() > 0 {
+		src := active[0]
+		if first || src.key != last {
+			fn(src.key)
+			last = src.key
+			first = false
+		}
+		if src.advance() {
+			heap.Fix(&active, 0)
+		} else {
+			heap.Pop(&active)
+		}
+	}
+	for _, src := range sources {
+		if src.err != nil && src.err() != nil {
+			return src.err()
+		}
+	}
+	return nil
+}
+
+// Len returns the number of distinct keys.
+func (self *Set) Len() (uint, error) {
+	var n uint
+	err := self.Walk(func(string) { n++ })
+	return n, err
+}
+
+// Close removes all spill files.
+func (self *Set) Close() {
+	self.access.Lock()
+	defer self.access.Unlock()
+	for _, run := range self.runs {
+		os.Remove(run)
+	}
+	self.runs = make([]string, 0)
+	balancing.Lock()
+	delete(live, self)
+	balancing.Unlock()
+	atomic.AddInt64(&used, -self.size)
+	self.keys = make(map[string]struct{})
+	self.size = 0
+}
+
+// Join builds a key from several parts. The separator sorts before any
+// character of a domain name in presentation format, so keys sharing the
+// first part are always walked together.
+func Join(parts ...string) string {
+	return strings.Join(parts, "\x00")
+}
+
+// Split is the reverse of Join.
+func Split(key string) []string {
+	return strings.Split(key, "\x00")
+}
+
+type source struct {
+	key  string
+	next func() (string, bool)
+	err  func() error
+}
+
+func (self *source) advance() bool {
+	key, ok := self.next()
+	self.key = key
+	return ok
+}
+
+type mergeHeap []*source
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].key < h[j].key }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*source)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
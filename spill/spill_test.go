@@ -0,0 +1,128 @@
+package spill
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// keys returns n random keys, with duplicates
+func keys(n int, seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	list := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		list = append(list, fmt.Sprintf("domain%d.se.", r.Intn(n/2)))
+	}
+	return list
+}
+
+func distinct(list []string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, key := range list {
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func walk(t *testing.T, set *Set) []string {
+	t.Helper()
+	result := make([]string, 0)
+	err := set.Walk(func(key string) { result = append(result, key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func withLimit(t *testing.T, limit int64) {
+	t.Helper()
+	oldLimit, oldDir := Limit, Dir
+	Limit, Dir = limit, t.TempDir()
+	t.Cleanup(func() { Limit, Dir = oldLimit, oldDir })
+}
+
+func runFiles(t *testing.T) int {
+	t.Helper()
+	files, err := os.ReadDir(Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+// TestSpilled checks that sets give the same keys with and without spilling
+func TestSpilled(t *testing.T) {
+	large, small := keys(20000, 1), keys(200, 2)
+	want := [][]string{distinct(large), distinct(small)}
+	for _, limit := range []int64{0, 100000, 1} {
+		withLimit(t, limit)
+		a, b := New(), New()
+		for i, key := range large {
+			if err := a.Add(key); err != nil {
+				t.Fatal(err)
+			}
+			if i%100 == 0 {
+				if err := b.Add(small[i/100]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if got := walk(t, a); !reflect.DeepEqual(got, want[0]) {
+			t.Errorf("limit %d: large set has %d keys, want %d", limit, len(got), len(want[0]))
+		}
+		if got := walk(t, b); !reflect.DeepEqual(got, want[1]) {
+			t.Errorf("limit %d: small set has %d keys, want %d", limit, len(got), len(want[1]))
+		}
+		if limit == 0 && a.Spilled() {
+			t.Error("set without limit spilled")
+		}
+		if limit > 0 && !a.Spilled() {
+			t.Errorf("limit %d: set did not spill", limit)
+		}
+		// the walk merged the runs in stages
+		if len(a.runs) > MAXRUNS {
+			t.Errorf("limit %d: %d runs after walk", limit, len(a.runs))
+		}
+		a.Close()
+		b.Close()
+		if n := runFiles(t); n != 0 {
+			t.Errorf("limit %d: %d run files left after close", limit, n)
+		}
+	}
+}
+
+// TestLargest checks that the largest set spills, not the one crossing
+// the limit
+func TestLargest(t *testing.T) {
+	withLimit(t, 100*(OVERHEAD+20))
+	large, small := New(), New()
+	defer large.Close()
+	defer small.Close()
+	for i := 0; i < 1000; i++ {
+		if err := large.Add(fmt.Sprintf("large%d.se.", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i%50 == 0 {
+			if err := small.Add(fmt.Sprintf("small%d.se.", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if small.Spilled() {
+		t.Error("small set spilled")
+	}
+	if !large.Spilled() {
+		t.Error("large set did not spill")
+	}
+	if len(large.runs) > 20 {
+		t.Errorf("large set has %d runs", len(large.runs))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ulrichwisser/zonestats/outputs"
+)
+
+// Time sources of the run timestamp. Any other value is taken as the
+// timestamp itself.
+const (
+	NOW    = "now"
+	SERIAL = "serial"
+	MTIME  = "mtime"
+)
+
+// runTimestamp returns the timestamp of all points of the run, truncated
+// to the configured precision. Serial is the SOA serial of the zone or -1.
+func runTimestamp(config *Configuration, serial int64) (time.Time, error) {
+	var timestamp time.Time
+	switch config.Timestamp {
+	case "", NOW:
+		timestamp = time.Now()
+	case SERIAL:
+		if serial < 0 {
+			return timestamp, errors.New("timestamp from serial, but no SOA record read")
+		}
+		var err error
+		timestamp, err = serialDate(serial)
+		if err != nil {
+			return timestamp, err
+		}
+	case MTIME:
+		info, err := os.Stat(config.Filename)
+		if err != nil {
+			return timestamp, err
+		}
+		timestamp = info.ModTime()
+	default:
+		var err error
+		timestamp, err = parseTimestamp(config.Timestamp)
+		if err != nil {
+			return timestamp, err
+		}
+	}
+	return timestamp.UTC().Truncate(outputs.PRECISIONS[config.TimePrecision]), nil
+}
+
+// serialDate decodes a serial in the format YYYYMMDDnn to midnight UTC of
+// that date.
+func serialDate(serial int64) (time.Time, error) {
+	timestamp, err := time.Parse("20060102", strconv.FormatInt(serial/100, 10))
+	if err != nil || timestamp.Year() < 1985 {
+		return timestamp, fmt.Errorf("serial %d is not a date (YYYYMMDDnn)", serial)
+	}
+	return timestamp, nil
+}
+
+// parseTimestamp accepts RFC 3339 timestamps, dates (YYYY-MM-DD) and
+// seconds since the epoch.
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return timestamp, nil
+	}
+	if timestamp, err := time.Parse("2006-01-02", value); err == nil {
+		return timestamp, nil
+	}
+	return time.Time{}, fmt.Errorf("timestamp %s is neither %s, %s, %s, a RFC 3339 time, a date nor seconds since the epoch", value, NOW, SERIAL, MTIME)
+}
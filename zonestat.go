@@ -1,27 +1,76 @@
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
-	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"log/slog"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/canonical"
+	"github.com/ulrichwisser/zonestats/checkpoint"
+	"github.com/ulrichwisser/zonestats/derived"
 	"github.com/ulrichwisser/zonestats/dnsresolver"
 	"github.com/ulrichwisser/zonestats/inputs/axfr"
 	"github.com/ulrichwisser/zonestats/inputs/zonefile"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/outputs/archive"
+	"github.com/ulrichwisser/zonestats/outputs/export"
+	"github.com/ulrichwisser/zonestats/outputs/graphite"
+	"github.com/ulrichwisser/zonestats/outputs/influx"
+	"github.com/ulrichwisser/zonestats/outputs/otlp"
+	"github.com/ulrichwisser/zonestats/outputs/parquet"
+	"github.com/ulrichwisser/zonestats/outputs/prometheus"
+	"github.com/ulrichwisser/zonestats/outputs/sqlite"
 	"github.com/ulrichwisser/zonestats/plugins/countdom"
+	"github.com/ulrichwisser/zonestats/plugins/counter"
 	"github.com/ulrichwisser/zonestats/plugins/countrr"
 	"github.com/ulrichwisser/zonestats/plugins/dnssec"
+	"github.com/ulrichwisser/zonestats/plugins/external"
+	"github.com/ulrichwisser/zonestats/plugins/lame"
 	"github.com/ulrichwisser/zonestats/plugins/nsstats"
+	"github.com/ulrichwisser/zonestats/progress"
+	"github.com/ulrichwisser/zonestats/sample"
+	"github.com/ulrichwisser/zonestats/shard"
+	"github.com/ulrichwisser/zonestats/spill"
 )
 
 type Plugin interface {
 	Receive(dns.RR, *sync.WaitGroup)
 	Done()
-	Influx(tld string, source string) string
+	Points(tld string, source string) []outputs.Point
+}
+
+// Mergeable plugins can be used in sharded runs. They save their state
+// into a partial result and merge the states of all shards afterwards.
+type Mergeable interface {
+	Name() string
+	SavePartial() ([]byte, error)
+	MergePartial([]byte) error
+}
+
+// Spilling mergeables keep name sets which may be larger than memory. The
+// sets are not part of SavePartial, they are written to the partial result
+// key by key and added to the sets of the same name when merging.
+type Spilling interface {
+	Sets() map[string]*spill.Set
+}
+
+// Ordered plugins receive the records one by one in the order of the zone.
+// Their Receive is called by the loop reading the records, so it must not
+// block for long. All other plugins receive the records concurrently.
+type Ordered interface {
+	InOrder()
+}
+
+// Dependent plugins and sinks use shared datasets of the registry. Needs
+// returns the names of the datasets, Use hands over the registry before the
+// run starts. The datasets can be read in Done and Write.
+type Dependent interface {
+	Needs() []string
+	Use(*derived.Registry)
 }
 
 type stringslice []string
@@ -36,90 +85,411 @@ func (str *stringslice) Set(value string) error {
 }
 
 var plugins = make([]Plugin, 0)
+var registry *derived.Registry
+var sinks = make([]outputs.Sink, 0)
 
 func main() {
+	// "zonestats report --html out.html results.json" renders the results of a run
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		runReport(os.Args[2:])
+		return
+	}
+
+	// "zonestats merge [options] partial..." merges the results of a sharded run
+	merge := len(os.Args) > 1 && os.Args[1] == "merge"
+	if merge {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	config := joinConfig(readDefaultConfigFiles(), parseCmdline())
+	config.Merge = merge
+	initLogging(config)
 	checkConfiguration(config)
-	initPlugins(config)
+	initSpill(config)
+	initSinks(config)
 
-	if config.Source == "axfr" {
-		runPlugins(axfr.GetZone(config.Zone, config.Axfr, config.Port))
-	}
-	if config.Source == "file" {
-		runPlugins(zonefile.GetZone(config.Filename, config.Zone))
+	// timestamp of all points
+	var timestamp time.Time
+
+	if config.Merge {
+		var serial int64
+		timestamp, serial = mergePartials(config, flag.Args())
+		initRunStats(config, nil)
+		stats.serial = serial
+	} else {
+		initPlugins(config)
+
+		run := progress.New()
+		initRunStats(config, run)
+		var rrlist <-chan dns.RR
+		if config.Source == "axfr" {
+			rrlist = axfr.GetZone(config.Zone, config.Axfr, config.Port, run)
+		}
+		if config.Source == "file" {
+			rrlist = zonefile.GetZone(config.Filename, config.Zone, run)
+		}
+		rrlist = canonical.Filter(run.Count(rrlist))
+		if len(config.Checkpoint) > 0 {
+			serial, list, err := checkpoint.Serial(rrlist)
+			if err != nil {
+				panic(err)
+			}
+			rrlist = list
+			initCheckpoints(config, serial)
+			if config.Resume {
+				loadCheckpoint()
+			}
+			startCheckpoints()
+		}
+		if config.Shards > 0 {
+			rrlist = shard.Filter(rrlist, config.Zone, config.Shard, config.Shards)
+		}
+		if checkpointing() {
+			rrlist = skipCheckpoint(rrlist)
+		}
+		run.Start(time.Duration(config.Progress) * time.Second)
+		runPlugins(rrlist)
+		run.Stop()
+		run.Report()
+
+		var err error
+		timestamp, err = runTimestamp(config, run.Serial())
+		if err != nil {
+			panic(err)
+		}
+
+		if config.Shards > 0 {
+			savePartial(config, run.Serial(), timestamp)
+			if checkpointing() {
+				finishCheckpoints()
+			}
+			return
+		}
 	}
 
 	donePlugins()
+	if checkpointing() {
+		writeCache()
+	}
 
-	runInflux(config)
+	// sinks may read the shared datasets, they are removed afterwards
+	ok := runSinks(config, timestamp)
+	registry.Close()
+	if !ok {
+		os.Exit(1)
+	}
+
+	if checkpointing() {
+		finishCheckpoints()
+	}
+}
+
+func initSpill(config *Configuration) {
+	spill.Limit = int64(config.MemoryLimit) << 20
+	if len(config.SpillDir) > 0 {
+		spill.Dir = config.SpillDir
+	}
 }
 
 func initPlugins(config *Configuration) {
-	plugins = append(plugins, countdom.Init())
+	var resolver *dnsresolver.Resolver
+	if !config.Offline && !config.Merge {
+		resolver = dnsresolver.New(config.Resolvers)
+	}
+	var precision uint
+	if config.Approximate {
+		precision = config.Precision
+	}
+	registry = derived.New(config.Zone, resolver, config.Offline, config.Approximate)
+	plugins = append(plugins, countdom.Init(precision))
 	plugins = append(plugins, countrr.Init())
-	plugins = append(plugins, dnssec.Init())
-	plugins = append(plugins, nsstats.Init(config.Zone, dnsresolver.New(config.Resolvers)))
+	plugins = append(plugins, dnssec.Init(precision))
+	plugins = append(plugins, nsstats.Init())
+	if config.Lame {
+		plugins = append(plugins, lame.Init(sample.New(config.Sample, config.Seed), config.Stratify))
+	}
+	for _, conf := range config.Counters {
+		plugin, err := counter.Init(conf)
+		if err != nil {
+			panic(err)
+		}
+		plugins = append(plugins, plugin)
+	}
+	for _, conf := range config.External {
+		plugin, err := external.Init(conf)
+		if err != nil {
+			panic(err)
+		}
+		plugins = append(plugins, plugin)
+	}
 	//plugins = append(plugins, unregns.Init())
+
+	// build the shared datasets needed, sinks may use them too
+	for _, plugin := range plugins {
+		if dependent, ok := plugin.(Dependent); ok {
+			useRegistry(dependent)
+		}
+	}
+	for _, sink := range sinks {
+		if dependent, ok := sink.(Dependent); ok {
+			useRegistry(dependent)
+		}
+	}
+}
+
+func useRegistry(dependent Dependent) {
+	err := registry.Require(dependent.Needs()...)
+	if err != nil {
+		panic(err)
+	}
+	dependent.Use(registry)
+}
+
+// mergeables returns the registry and all plugins for sharded runs
+func mergeables() []Mergeable {
+	list := []Mergeable{registry}
+	names := map[string]bool{registry.Name(): true}
+	for _, plugin := range plugins {
+		mergeable, ok := plugin.(Mergeable)
+		if !ok {
+			panic(fmt.Errorf("plugin %T can not be used in sharded runs", plugin))
+		}
+		if names[mergeable.Name()] {
+			panic(fmt.Errorf("plugin name %s used more than once", mergeable.Name()))
+		}
+		names[mergeable.Name()] = true
+		list = append(list, mergeable)
+	}
+	return list
 }
 
 func runPlugins(rrlist <-chan dns.RR) {
+	ordered := make([]bool, len(plugins))
+	for i, plugin := range plugins {
+		_, ordered[i] = plugin.(Ordered)
+	}
 	var wg sync.WaitGroup
 	for rr := range rrlist {
-		for _, plugin := range plugins {
-			wg.Add(1)
-			go plugin.Receive(rr, &wg)
+		wg.Add(1)
+		go registry.Receive(rr, &wg)
+		for i, plugin := range plugins {
+			if ordered[i] {
+				wg.Add(1)
+				start := time.Now()
+				plugin.Receive(rr, &wg)
+				stats.addReceive(i, time.Since(start))
+				continue
+			}
+			// one for Receive, one for measuring its time
+			wg.Add(2)
+			go func(i int, plugin Plugin, rr dns.RR) {
+				defer wg.Done()
+				start := time.Now()
+				plugin.Receive(rr, &wg)
+				stats.addReceive(i, time.Since(start))
+			}(i, plugin, rr)
+		}
+		if checkpointing() {
+			received(rr, &wg)
 		}
 	}
 	wg.Wait()
+	if checkpointing() {
+		doneReading()
+	}
 }
 
 func donePlugins() {
-	for _, plugin := range plugins {
+	for i, plugin := range plugins {
+		start := time.Now()
 		plugin.Done()
+		stats.setDone(i, time.Since(start))
 	}
 }
 
-func runInflux(config *Configuration) {
+func savePartial(config *Configuration, serial int64, timestamp time.Time) {
+	partial, err := newPartial(config, serial, timestamp)
+	if err != nil {
+		panic(err)
+	}
+	err = partial.Write(config.Partial)
+	if err != nil {
+		panic(err)
+	}
+	slog.Info("partial result written", "shard", config.Shard, "shards", config.Shards, "file", config.Partial)
+}
 
-	// collect line data
-	lines := ""
-	for _, plugin := range plugins {
-		lines = lines + plugin.Influx(config.Zone, config.Source)
+// newPartial returns the state of the registry and all plugins as partial
+// result
+func newPartial(config *Configuration, serial int64, timestamp time.Time) (*shard.Partial, error) {
+	var precision uint
+	if config.Approximate {
+		precision = config.Precision
+	}
+	partial := shard.New(config.Zone, config.Source, config.Offline, precision, config.Shard, config.Shards)
+	partial.Serial = serial
+	partial.Time = timestamp
+	for _, mergeable := range mergeables() {
+		state, err := mergeable.SavePartial()
+		if err != nil {
+			return nil, err
+		}
+		partial.Plugins[mergeable.Name()] = state
+		for name, set := range spillingSets(mergeable) {
+			partial.Sets[mergeable.Name()+"."+name] = set
+		}
 	}
+	return partial, nil
+}
 
-	// compute InfluxDB URL
-	sessionurl, err := url.Parse(config.InfluxServer)
-	sessionurl.Path = "write"
-	q := sessionurl.Query()
-	q.Set("db", config.InfluxDB)
-	sessionurl.RawQuery = q.Encode()
+// spillingSets returns the name sets of a mergeable, if it has any
+func spillingSets(mergeable Mergeable) map[string]*spill.Set {
+	if spilling, ok := mergeable.(Spilling); ok {
+		return spilling.Sets()
+	}
+	return nil
+}
 
-	req, err := http.NewRequest(http.MethodPost, sessionurl.String(), bytes.NewBufferString(lines))
+// mergePartials merges the partial results of all shards. It returns the
+// timestamp of the partial results, unless another timestamp is configured,
+// and the serial of the zone.
+func mergePartials(config *Configuration, filenames []string) (time.Time, int64) {
+	partials := make([]*shard.Partial, 0)
+	for _, filename := range filenames {
+		partial, err := shard.Read(filename)
+		if err != nil {
+			panic(err)
+		}
+		partials = append(partials, partial)
+	}
+	err := shard.Check(partials)
 	if err != nil {
 		panic(err)
 	}
-	if len(config.InfluxUser) > 0 {
-		req.SetBasicAuth(config.InfluxUser, config.InfluxPasswd)
+
+	// zone and source are taken from the partial results
+	config.Zone = canonicalZone(partials[0].Zone)
+	config.Source = partials[0].Source
+	config.Offline = partials[0].Offline
+	config.Approximate = partials[0].Precision > 0
+	config.Precision = partials[0].Precision
+	initPlugins(config)
+
+	mergeInto(partials)
+
+	if len(config.Timestamp) == 0 {
+		return partials[0].Time, partials[0].Serial
+	}
+	timestamp, err := runTimestamp(config, partials[0].Serial)
+	if err != nil {
+		panic(err)
 	}
+	return timestamp, partials[0].Serial
+}
 
-	if !config.Dryrun {
-		_, err = http.DefaultClient.Do(req)
+// mergeInto adds the states of the partial results to the registry and all
+// plugins
+func mergeInto(partials []*shard.Partial) {
+	for _, mergeable := range mergeables() {
+		for _, partial := range partials {
+			state, ok := partial.Plugins[mergeable.Name()]
+			if !ok {
+				panic(fmt.Errorf("shard %d has no result for plugin %s", partial.Shard, mergeable.Name()))
+			}
+			err := mergeable.MergePartial(state)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	sets := make(map[string]*spill.Set)
+	for _, mergeable := range mergeables() {
+		for name, set := range spillingSets(mergeable) {
+			sets[mergeable.Name()+"."+name] = set
+		}
+	}
+	for _, partial := range partials {
+		err := partial.ReadSets(sets)
 		if err != nil {
 			panic(err)
 		}
-	} else {
-		fmt.Println("DRYRUN! No actual call to InfluxDB has been made. The following call would have been made without --dryrun")
-		requestDump, err := httputil.DumpRequest(req, true)
-		if err != nil {
-			fmt.Println(err)
+	}
+}
+
+func initSinks(config *Configuration) {
+	for _, conf := range config.Sinks {
+		switch conf.Type {
+		case outputs.INFLUX:
+			sinks = append(sinks, influx.New(influx.Config{
+				Version:   config.InfluxVersion,
+				Server:    config.InfluxServer,
+				DB:        config.InfluxDB,
+				User:      config.InfluxUser,
+				Passwd:    config.InfluxPasswd,
+				Org:       config.InfluxOrg,
+				Bucket:    config.InfluxBucket,
+				Token:     config.InfluxToken,
+				Precision: config.InfluxPrecision,
+				Batch:     config.InfluxBatch,
+				Gzip:      config.InfluxGzip,
+				Retries:   config.InfluxRetries,
+				Spool:     config.InfluxSpool,
+			}, config.Dryrun))
+		case outputs.ARCHIVE:
+			sinks = append(sinks, archive.New(conf.File))
+		case outputs.PROMETHEUS:
+			sinks = append(sinks, prometheus.NewTextfile(conf.File))
+		case outputs.PUSHGATEWAY:
+			sinks = append(sinks, prometheus.NewPushgateway(conf.URL, conf.Job))
+		case outputs.GRAPHITE:
+			sinks = append(sinks, graphite.New(conf.Address, conf.Template))
+		case outputs.STATSD:
+			sinks = append(sinks, graphite.NewStatsd(conf.Address, conf.Template))
+		case outputs.JSON, outputs.CSV:
+			sinks = append(sinks, export.New(conf.Type, conf.File))
+		case outputs.OTLP:
+			sinks = append(sinks, otlp.New(conf.URL, conf.Resource, conf.Headers))
+		case outputs.SQLITE:
+			sinks = append(sinks, sqlite.New(conf.File, conf.Delegations))
+		case outputs.PARQUET:
+			sinks = append(sinks, parquet.New(conf.File))
 		}
-		fmt.Println(string(requestDump))
+	}
+}
+
+// runSinks writes the points of all plugins with the timestamp of the run
+// and the static tags to every sink. A failing sink does not keep the points
+// from being written to the other sinks. It returns false if a sink failed
+// that is not optional.
+func runSinks(config *Configuration, timestamp time.Time) bool {
+	points := make([]outputs.Point, 0)
+	for _, plugin := range plugins {
+		points = append(points, plugin.Points(config.Zone, config.Source)...)
+	}
+	points = append(points, stats.Points(config.Zone, config.Source)...)
+	for _, limit := range config.TagLimits {
+		points = outputs.Limit(points, limit)
+	}
+	outputs.AddTags(points, config.Tags)
+	for i := range points {
+		points[i].Time = timestamp
+	}
+	run := stats.Run(config, timestamp)
 
-		//		fmt.Printf("http://%s:%s/write?db=%s\n", config.InfluxServer, config.InfluxPort, config.InfluxDB)
-		//		if len(config.InfluxUser) > 0 {
-		//			auth := config.InfluxUser + ":" + config.InfluxPasswd
-		//			fmt.Printf("Authorization: %s\n", base64.StdEncoding.EncodeToString([]byte(auth)))
-		//		}
-		//		fmt.Println(lines)
+	ok := true
+	for i, sink := range sinks {
+		if recorder, isrecorder := sink.(outputs.Recorder); isrecorder {
+			recorder.SetRun(run)
+		}
+		err := sink.Write(points)
+		if err != nil {
+			slog.Error("writing results failed", "sink", sink.Name(), "optional", config.Sinks[i].Optional, "error", err)
+			if !config.Sinks[i].Optional {
+				ok = false
+			}
+			continue
+		}
+		slog.Debug("results written", "sink", sink.Name(), "points", len(points))
 	}
+	return ok
 }
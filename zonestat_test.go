@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/ulrichwisser/zonestats/canonical"
+	"github.com/ulrichwisser/zonestats/inputs/zonefile"
+	"github.com/ulrichwisser/zonestats/outputs"
+	"github.com/ulrichwisser/zonestats/outputs/export"
+)
+
+// ZONE has three name server hosts in zone and one out of zone, names in
+// upper case are the same as in lower case
+const ZONE = `$TTL 3600
+se.        SOA   a.ns.se. hostmaster.se. 1 7200 3600 604800 3600
+se.        NS    a.ns.se.
+a.ns.se.   A     192.0.2.1
+b.se.      NS    ns.b.se.
+ns.b.se.   A     192.0.2.2
+c.se.      NS    ns.c.se.
+ns.c.se.   AAAA  2001:db8::3
+d.se.      NS    ns.example.com.
+d.se.      NS    ns.b.se.
+d.se.      DS    12345 8 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF
+e.se.      NS    ns.example.com.
+E.SE.      NS    NS.EXAMPLE.COM.
+e.se.      DS    54321 13 2 FEDCBA9876543210FEDCBA9876543210FEDCBA9876543210FEDCBA9876543210
+f.se.      NS    a.ns.se.
+`
+
+// TestMixedCaseZone runs with a zone given in upper case against a zone
+// file in lower case
+func TestMixedCaseZone(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "se.zone")
+	if err := os.WriteFile(filename, []byte(ZONE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{Zone: "SE", Filename: filename, Offline: true, Port: 53, Dryrun: true}
+	checkConfiguration(config)
+	if config.Zone != "se" {
+		t.Fatalf("zone %q, want se", config.Zone)
+	}
+
+	startRun(config)
+	runPlugins(feed(readZone(t, config)))
+	donePlugins()
+	defer registry.Close()
+
+	for _, plugin := range plugins {
+		for _, point := range plugin.Points(config.Zone, config.Source) {
+			if point.Tags["tld"] != "se" {
+				t.Errorf("%s has tld %q", point.Measurement, point.Tags["tld"])
+			}
+			if point.Measurement != "Hosts" {
+				continue
+			}
+			if point.Fields["InTld"] != int64(3) || point.Fields["ExTld"] != int64(1) {
+				t.Errorf("hosts InTld=%v ExTld=%v, want 3 and 1", point.Fields["InTld"], point.Fields["ExTld"])
+			}
+		}
+	}
+}
+
+// readZone returns the records of the zone in canonical form
+func readZone(t *testing.T, config *Configuration) []dns.RR {
+	rrs := make([]dns.RR, 0)
+	for rr := range canonical.Filter(zonefile.GetZone(config.Filename, config.Zone, nil)) {
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func feed(rrs []dns.RR) <-chan dns.RR {
+	rrlist := make(chan dns.RR, len(rrs))
+	for _, rr := range rrs {
+		rrlist <- rr
+	}
+	close(rrlist)
+	return rrlist
+}
+
+// startRun sets up new plugins and a new registry
+func startRun(config *Configuration) {
+	plugins = plugins[:0]
+	checkpoints = checkpointState{}
+	initPlugins(config)
+	initRunStats(config, nil)
+}
+
+// results returns the points of all plugins sorted
+func results(config *Configuration) []outputs.Point {
+	points := make([]outputs.Point, 0)
+	for _, plugin := range plugins {
+		points = append(points, plugin.Points(config.Zone, config.Source)...)
+	}
+	return export.Sort(points)
+}
+
+// TestResume checks that a run resumed from the checkpoint of a run which
+// died after half of the records has the same results as a run without
+// checkpoints
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "se.zone")
+	if err := os.WriteFile(filename, []byte(ZONE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{Zone: "se", Filename: filename, Offline: true, Port: 53, Dryrun: true}
+	checkConfiguration(config)
+	rrs := readZone(t, config)
+
+	startRun(config)
+	runPlugins(feed(rrs))
+	donePlugins()
+	want := results(config)
+	registry.Close()
+
+	config.Checkpoint = filepath.Join(dir, "checkpoint")
+	config.CheckpointInterval = 3600
+	startRun(config)
+	initCheckpoints(config, 1)
+	runPlugins(feed(rrs[:len(rrs)/2]))
+	registry.Close()
+
+	startRun(config)
+	initCheckpoints(config, 1)
+	loadCheckpoint()
+	if checkpoints.written != uint64(len(rrs)/2) {
+		t.Fatalf("checkpoint has %d records, want %d", checkpoints.written, len(rrs)/2)
+	}
+	startCheckpoints()
+	runPlugins(skipCheckpoint(feed(rrs)))
+	donePlugins()
+	got := results(config)
+	registry.Close()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed run\n%v\nwant\n%v", got, want)
+	}
+
+	finishCheckpoints()
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left after the run, want only the zone", len(files))
+	}
+}
+
+// sequence records the names of the records in the order received
+type sequence struct {
+	names []string
+}
+
+func (self *sequence) InOrder() {}
+
+func (self *sequence) Receive(rr dns.RR, wg *sync.WaitGroup) {
+	defer wg.Done()
+	self.names = append(self.names, rr.Header().Name)
+}
+
+func (self *sequence) Done() {}
+
+func (self *sequence) Points(tld string, source string) []outputs.Point {
+	return nil
+}
+
+// TestOrdered checks that ordered plugins receive the records in the order
+// of the zone
+func TestOrdered(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "se.zone")
+	if err := os.WriteFile(filename, []byte(ZONE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Configuration{Zone: "se", Filename: filename, Offline: true, Port: 53, Dryrun: true}
+	checkConfiguration(config)
+	rrs := readZone(t, config)
+
+	startRun(config)
+	seq := &sequence{}
+	plugins = append(plugins, seq)
+	initRunStats(config, nil)
+	runPlugins(feed(rrs))
+	donePlugins()
+	registry.Close()
+
+	want := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		want = append(want, rr.Header().Name)
+	}
+	if !reflect.DeepEqual(seq.names, want) {
+		t.Errorf("received\n%v\nwant\n%v", seq.names, want)
+	}
+}